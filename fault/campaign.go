@@ -0,0 +1,142 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"fmt"
+)
+
+// Experiment outcomes
+const (
+	// NotTriggered indicates that the fault trigger condition was never
+	// met.
+	NotTriggered = iota
+	// Masked indicates that the fault was injected without any error
+	// being raised.
+	Masked
+	// Detected indicates that the fault was injected and detected.
+	Detected
+	// Undetected indicates that the fault was injected and an error, not
+	// classified as detection, was raised.
+	Undetected
+)
+
+// Result represents a single fault injection experiment result.
+type Result struct {
+	// Fault is the experiment fault
+	Fault Fault
+	// Outcome is the experiment outcome
+	Outcome int
+
+	// Injected is the number of completed scheduling cycles at fault
+	// injection
+	Injected uint
+	// Detected is the number of completed scheduling cycles at fault
+	// detection, including the detecting one.
+	Detected uint
+	// Latency is the number of scheduling cycles executed after fault
+	// injection up to its detection, including the detecting one.
+	Latency uint
+
+	// Err is the error returned by the experiment
+	Err error
+}
+
+// String returns a description of the experiment result.
+func (r *Result) String() string {
+	switch r.Outcome {
+	case NotTriggered:
+		return fmt.Sprintf("%s: not triggered", r.Fault.String())
+	case Masked:
+		return fmt.Sprintf("%s: masked", r.Fault.String())
+	case Detected:
+		return fmt.Sprintf("%s: detected after %d cycles (%v)", r.Fault.String(), r.Latency, r.Err)
+	default:
+		return fmt.Sprintf("%s: undetected (%v)", r.Fault.String(), r.Err)
+	}
+}
+
+// Campaign represents a fault injection campaign, where each fault is
+// injected in a distinct experiment.
+type Campaign struct {
+	// Faults is the list of faults, one for each experiment.
+	Faults []Fault
+
+	// Run executes a single experiment from its initial state, it must
+	// pass the argument injector to the execution context (e.g.
+	// monitor.ExecCtx Injector field) or, on host simulations, invoke its
+	// Step() at each scheduling cycle.
+	//
+	// The function must return the execution context Run() error.
+	Run func(inj *Injector) error
+
+	// Detected, if not nil, classifies experiment errors as fault
+	// detection (e.g. errors.As() with monitor.LockstepError), when nil
+	// any error is considered a detection.
+	Detected func(err error) bool
+}
+
+// Execute runs all campaign experiments, recording their outcome and
+// detection latency.
+func (c *Campaign) Execute() (results []Result) {
+	for _, f := range c.Faults {
+		inj := NewInjector(f)
+		err := c.Run(inj)
+
+		r := Result{
+			Fault: f,
+			Err:   err,
+		}
+
+		switch {
+		case len(inj.Injected) == 0:
+			r.Outcome = NotTriggered
+		case err == nil:
+			r.Outcome = Masked
+		case c.Detected == nil || c.Detected(err):
+			r.Outcome = Detected
+		default:
+			r.Outcome = Undetected
+		}
+
+		if len(inj.Injected) > 0 {
+			r.Injected = inj.Injected[0].Cycle
+		}
+
+		// the error is raised by the cycle following the last Step()
+		if r.Outcome == Detected {
+			r.Detected = inj.Cycle + 1
+			r.Latency = r.Detected - r.Injected
+		}
+
+		results = append(results, r)
+	}
+
+	return
+}
+
+// Coverage returns the ratio of detected faults over injected ones.
+func Coverage(results []Result) float64 {
+	var injected, detected int
+
+	for _, r := range results {
+		if r.Outcome == NotTriggered {
+			continue
+		}
+
+		injected += 1
+
+		if r.Outcome == Detected {
+			detected += 1
+		}
+	}
+
+	if injected == 0 {
+		return 0
+	}
+
+	return float64(detected) / float64(injected)
+}
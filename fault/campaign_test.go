@@ -0,0 +1,131 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"errors"
+	"testing"
+)
+
+var (
+	errMismatch = errors.New("mismatch")
+	errCrash    = errors.New("crash")
+)
+
+// lockstep simulates a primary and shadow execution, comparing their register
+// state after each scheduling cycle, register 1 holds a step counter while
+// register 0 is never compared.
+func lockstep(cycles uint, crash int) func(inj *Injector) error {
+	return func(inj *Injector) error {
+		primary := newSim()
+		shadow := newSim()
+
+		for cycle := uint(0); cycle < cycles; cycle++ {
+			if err := inj.Step(cycle, primary, shadow); err != nil {
+				return err
+			}
+
+			if crash >= 0 && primary.Regs[crash] != 0 {
+				return errCrash
+			}
+
+			primary.Regs[1] += 1
+			shadow.Regs[1] += 1
+
+			if primary.Regs[1] != shadow.Regs[1] {
+				return errMismatch
+			}
+		}
+
+		return nil
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	c := &Campaign{
+		Faults: []Fault{
+			{Kind: RegisterFlip, Register: 1, Bit: 4, Cycle: 0},
+			{Kind: RegisterFlip, Register: 1, Bit: 4, Cycle: 3},
+			{Kind: RegisterFlip, Register: 0, Bit: 0, Cycle: 2},
+			{Kind: RegisterFlip, Register: 2, Bit: 0, Cycle: 1},
+			{Kind: RegisterFlip, Register: 1, Bit: 0, Cycle: 10},
+		},
+		Run: lockstep(5, 2),
+		Detected: func(err error) bool {
+			return errors.Is(err, errMismatch)
+		},
+	}
+
+	want := []struct {
+		outcome  int
+		injected uint
+		detected uint
+		latency  uint
+	}{
+		{Detected, 0, 1, 1},
+		{Detected, 3, 4, 1},
+		{Masked, 2, 0, 0},
+		{Undetected, 1, 0, 0},
+		{NotTriggered, 0, 0, 0},
+	}
+
+	results := c.Execute()
+
+	if len(results) != len(want) {
+		t.Fatalf("results = %d, want %d", len(results), len(want))
+	}
+
+	for i, r := range results {
+		w := want[i]
+
+		if r.Outcome != w.outcome || r.Injected != w.injected || r.Detected != w.detected || r.Latency != w.latency {
+			t.Errorf("%s: got outcome:%d injected:%d detected:%d latency:%d, want %+v",
+				r.Fault.String(), r.Outcome, r.Injected, r.Detected, r.Latency, w)
+		}
+	}
+
+	// 2 detected out of 4 injected
+	if cov := Coverage(results); cov != 0.5 {
+		t.Errorf("coverage = %v, want 0.5", cov)
+	}
+}
+
+func TestCampaignLatency(t *testing.T) {
+	// a fault on a register compared only every other cycle
+	run := func(inj *Injector) error {
+		s := newSim()
+
+		for cycle := uint(0); cycle < 8; cycle++ {
+			if err := inj.Step(cycle, s, nil); err != nil {
+				return err
+			}
+
+			if cycle%2 == 1 && s.Regs[5] != 0 {
+				return errMismatch
+			}
+		}
+
+		return nil
+	}
+
+	c := &Campaign{
+		Faults: []Fault{{Kind: RegisterFlip, Register: 5, Cycle: 2}},
+		Run:    run,
+	}
+
+	r := c.Execute()[0]
+
+	if r.Outcome != Detected || r.Detected != 4 || r.Latency != 2 {
+		t.Errorf("got outcome:%d detected:%d latency:%d, want detection at cycle 4 with latency 2",
+			r.Outcome, r.Detected, r.Latency)
+	}
+}
+
+func TestCoverageEmpty(t *testing.T) {
+	if cov := Coverage([]Result{{Outcome: NotTriggered}}); cov != 0 {
+		t.Errorf("coverage = %v, want 0", cov)
+	}
+}
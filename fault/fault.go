@@ -0,0 +1,210 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package fault implements fault injection for the validation of redundant
+// execution (e.g. monitor soft lockstep) and exception handlers.
+//
+// Faults are applied to a Target, which abstracts the execution context
+// register and memory state, at configured scheduling cycles or program
+// counter values.
+//
+// The package is architecture independent, it can therefore be used with
+// monitor execution contexts on hardware (see monitor.ExecCtx Injector field)
+// as well as with host simulations of their state (see Sim).
+package fault
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Fault kinds
+const (
+	// RegisterFlip flips a single register bit.
+	RegisterFlip = iota
+	// MemoryCorruption corrupts a 32-bit memory word.
+	MemoryCorruption
+	// InstructionSkip skips the next instruction.
+	InstructionSkip
+)
+
+// Target represents the state of an execution context subject to fault
+// injection.
+type Target interface {
+	// Registers returns the number of general purpose registers.
+	Registers() int
+	// Register returns the value of the argument general purpose
+	// register.
+	Register(n int) uint64
+	// SetRegister sets the value of the argument general purpose
+	// register.
+	SetRegister(n int, val uint64)
+
+	// PC returns the address of the next instruction to be executed.
+	PC() uint64
+	// Skip advances the program counter past the next instruction, an
+	// error is returned if the instruction cannot be decoded.
+	Skip() error
+
+	// Memory returns the execution context memory.
+	Memory() []byte
+}
+
+// Fault represents a single fault injection.
+type Fault struct {
+	// Kind is the fault kind (RegisterFlip, MemoryCorruption,
+	// InstructionSkip).
+	Kind int
+
+	// Shadow selects the redundant execution context, rather than the
+	// primary one, as fault target.
+	Shadow bool
+
+	// Cycle is the number of completed scheduling cycles after which the
+	// fault is injected, it is ignored when PC is set.
+	Cycle uint
+	// PC, if not zero, triggers the fault injection at the first
+	// scheduling cycle where the target program counter matches it.
+	PC uint64
+
+	// Register is the register number for RegisterFlip faults
+	Register int
+	// Bit is the register bit flipped by RegisterFlip faults
+	Bit int
+
+	// Offset is the memory offset, within the target Memory, of the word
+	// corrupted by MemoryCorruption faults.
+	Offset int
+	// Mask is the value XORed, in little-endian order, to the word
+	// corrupted by MemoryCorruption faults.
+	Mask uint32
+}
+
+// String returns a description of the fault.
+func (f *Fault) String() (s string) {
+	switch f.Kind {
+	case RegisterFlip:
+		s = fmt.Sprintf("register %d bit %d flip", f.Register, f.Bit)
+	case MemoryCorruption:
+		s = fmt.Sprintf("memory word %#x corruption (mask:%#x)", f.Offset, f.Mask)
+	case InstructionSkip:
+		s = "instruction skip"
+	default:
+		s = fmt.Sprintf("invalid fault kind %d", f.Kind)
+	}
+
+	if f.Shadow {
+		s += " on shadow"
+	}
+
+	if f.PC != 0 {
+		return s + fmt.Sprintf(" at PC %#x", f.PC)
+	}
+
+	return s + fmt.Sprintf(" at cycle %d", f.Cycle)
+}
+
+// Apply injects the fault in the argument target.
+func (f *Fault) Apply(t Target) (err error) {
+	if t == nil {
+		return errors.New("invalid fault target")
+	}
+
+	switch f.Kind {
+	case RegisterFlip:
+		if f.Register < 0 || f.Register >= t.Registers() || f.Bit < 0 || f.Bit > 63 {
+			return fmt.Errorf("invalid register %d bit %d", f.Register, f.Bit)
+		}
+
+		t.SetRegister(f.Register, t.Register(f.Register)^(1<<f.Bit))
+	case MemoryCorruption:
+		mem := t.Memory()
+
+		if f.Offset < 0 || f.Offset+4 > len(mem) {
+			return fmt.Errorf("invalid memory offset %#x", f.Offset)
+		}
+
+		word := binary.LittleEndian.Uint32(mem[f.Offset:])
+		binary.LittleEndian.PutUint32(mem[f.Offset:], word^f.Mask)
+	case InstructionSkip:
+		return t.Skip()
+	default:
+		return fmt.Errorf("invalid fault kind %d", f.Kind)
+	}
+
+	return
+}
+
+// Injection represents an injected fault.
+type Injection struct {
+	// Fault is the injected fault
+	Fault Fault
+	// Cycle is the scheduling cycle at injection
+	Cycle uint
+}
+
+// Injector represents a fault injector, each fault is injected only once as
+// soon as its trigger condition is met. Injectors can be created either with
+// NewInjector() or as a literal.
+type Injector struct {
+	// Faults is the list of faults to be injected
+	Faults []Fault
+	// Injected is the list of injected faults
+	Injected []Injection
+
+	// Cycle is the last scheduling cycle passed to Step()
+	Cycle uint
+
+	// injected faults
+	done map[int]bool
+}
+
+// NewInjector returns a fault injector for the argument faults.
+func NewInjector(faults ...Fault) *Injector {
+	return &Injector{
+		Faults: faults,
+	}
+}
+
+// Step evaluates all pending faults trigger conditions, injecting faults in
+// the primary or shadow target as required. It must be invoked before each
+// scheduling cycle with the number of completed ones.
+func (inj *Injector) Step(cycle uint, primary Target, shadow Target) (err error) {
+	inj.Cycle = cycle
+
+	if inj.done == nil {
+		inj.done = make(map[int]bool)
+	}
+
+	for i, f := range inj.Faults {
+		if inj.done[i] {
+			continue
+		}
+
+		t := primary
+
+		if f.Shadow {
+			t = shadow
+		}
+
+		if t == nil {
+			return fmt.Errorf("missing target for %s", f.String())
+		}
+
+		if f.PC != 0 && t.PC() != f.PC || f.PC == 0 && f.Cycle != cycle {
+			continue
+		}
+
+		if err = f.Apply(t); err != nil {
+			return
+		}
+
+		inj.done[i] = true
+		inj.Injected = append(inj.Injected, Injection{Fault: f, Cycle: cycle})
+	}
+
+	return
+}
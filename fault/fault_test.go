@@ -0,0 +1,182 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package fault
+
+import (
+	"bytes"
+	"testing"
+)
+
+func newSim() *Sim {
+	return &Sim{
+		Regs:            make([]uint64, 16),
+		ProgramCounter:  0x1000,
+		InstructionSize: 4,
+		Mem:             make([]byte, 64),
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		fault Fault
+		check func(*Sim) bool
+		err   bool
+	}{
+		{
+			name:  "register flip",
+			fault: Fault{Kind: RegisterFlip, Register: 3, Bit: 63},
+			check: func(s *Sim) bool { return s.Regs[3] == 1<<63 },
+		},
+		{
+			name:  "memory corruption",
+			fault: Fault{Kind: MemoryCorruption, Offset: 8, Mask: 0x04030201},
+			check: func(s *Sim) bool { return bytes.Equal(s.Mem[8:12], []byte{1, 2, 3, 4}) },
+		},
+		{
+			name:  "instruction skip",
+			fault: Fault{Kind: InstructionSkip},
+			check: func(s *Sim) bool { return s.ProgramCounter == 0x1004 },
+		},
+		{
+			name:  "invalid register",
+			fault: Fault{Kind: RegisterFlip, Register: 16},
+			err:   true,
+		},
+		{
+			name:  "invalid bit",
+			fault: Fault{Kind: RegisterFlip, Bit: 64},
+			err:   true,
+		},
+		{
+			name:  "invalid offset",
+			fault: Fault{Kind: MemoryCorruption, Offset: 61},
+			err:   true,
+		},
+		{
+			name:  "negative offset",
+			fault: Fault{Kind: MemoryCorruption, Offset: -1},
+			err:   true,
+		},
+		{
+			name:  "invalid kind",
+			fault: Fault{Kind: 42},
+			err:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSim()
+			err := tt.fault.Apply(s)
+
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !tt.check(s) {
+				t.Errorf("fault not applied, %+v", s)
+			}
+		})
+	}
+
+	if err := (&Fault{}).Apply(nil); err == nil {
+		t.Error("expected error on nil target")
+	}
+}
+
+func TestInjectorCycle(t *testing.T) {
+	s := newSim()
+	inj := NewInjector(Fault{Kind: RegisterFlip, Register: 1, Cycle: 2})
+
+	for cycle := uint(0); cycle < 5; cycle++ {
+		if err := inj.Step(cycle, s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(inj.Injected) != 1 || inj.Injected[0].Cycle != 2 {
+		t.Fatalf("injected = %+v, want single injection at cycle 2", inj.Injected)
+	}
+
+	// each fault is injected only once
+	if s.Regs[1] != 1 {
+		t.Errorf("register = %#x, want 1", s.Regs[1])
+	}
+
+	if inj.Cycle != 4 {
+		t.Errorf("cycle = %d, want 4", inj.Cycle)
+	}
+}
+
+func TestInjectorPC(t *testing.T) {
+	s := newSim()
+	inj := NewInjector(Fault{Kind: InstructionSkip, PC: 0x1008})
+
+	for cycle := uint(0); cycle < 4; cycle++ {
+		if err := inj.Step(cycle, s, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		s.ProgramCounter += 4
+	}
+
+	if len(inj.Injected) != 1 || inj.Injected[0].Cycle != 2 {
+		t.Fatalf("injected = %+v, want single injection at cycle 2", inj.Injected)
+	}
+
+	// 4 steps and 1 skip
+	if s.ProgramCounter != 0x1000+5*4 {
+		t.Errorf("pc = %#x, want %#x", s.ProgramCounter, 0x1000+5*4)
+	}
+}
+
+func TestInjectorShadow(t *testing.T) {
+	primary := newSim()
+	shadow := newSim()
+	inj := NewInjector(Fault{Kind: RegisterFlip, Register: 2, Shadow: true})
+
+	if err := inj.Step(0, primary, nil); err == nil {
+		t.Fatal("expected missing shadow target error")
+	}
+
+	if err := inj.Step(0, primary, shadow); err != nil {
+		t.Fatal(err)
+	}
+
+	if primary.Regs[2] != 0 || shadow.Regs[2] != 1 {
+		t.Errorf("primary = %#x, shadow = %#x", primary.Regs[2], shadow.Regs[2])
+	}
+}
+
+func TestInjectorLiteral(t *testing.T) {
+	s := newSim()
+	inj := &Injector{
+		Faults: []Fault{{Kind: RegisterFlip, Register: 3, Cycle: 1}},
+	}
+
+	for cycle := uint(0); cycle < 3; cycle++ {
+		if err := inj.Step(cycle, s, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(inj.Injected) != 1 || inj.Injected[0].Cycle != 1 {
+		t.Fatalf("injected = %+v, want single injection at cycle 1", inj.Injected)
+	}
+
+	if s.Regs[3] != 1 {
+		t.Errorf("register = %#x, want 1", s.Regs[3])
+	}
+}
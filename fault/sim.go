@@ -0,0 +1,50 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package fault
+
+// Sim represents a host simulation of execution context state, it implements
+// Target to allow fault injection campaigns without hardware.
+type Sim struct {
+	// Regs represents the general purpose registers
+	Regs []uint64
+	// ProgramCounter represents the address of the next instruction
+	ProgramCounter uint64
+	// InstructionSize is the program counter increment for Skip()
+	InstructionSize uint64
+	// Mem represents the execution context memory
+	Mem []byte
+}
+
+// Registers returns the number of simulated general purpose registers.
+func (s *Sim) Registers() int {
+	return len(s.Regs)
+}
+
+// Register returns the value of the argument simulated register.
+func (s *Sim) Register(n int) uint64 {
+	return s.Regs[n]
+}
+
+// SetRegister sets the value of the argument simulated register.
+func (s *Sim) SetRegister(n int, val uint64) {
+	s.Regs[n] = val
+}
+
+// PC returns the simulated program counter.
+func (s *Sim) PC() uint64 {
+	return s.ProgramCounter
+}
+
+// Skip advances the simulated program counter by InstructionSize.
+func (s *Sim) Skip() error {
+	s.ProgramCounter += s.InstructionSize
+	return nil
+}
+
+// Memory returns the simulated memory.
+func (s *Sim) Memory() []byte {
+	return s.Mem
+}
@@ -0,0 +1,371 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package manifest implements parsing and validation of declarative applet
+// manifests, describing the configuration of GoTEE execution contexts.
+//
+// A manifest is a JSON document (see Schema) holding the applet name and
+// UUID, its memory region, security state and privilege level, the allowed
+// system calls, its RPC services and restart policy. It is meant to be
+// shipped alongside the applet image, either as a separate file (see Open(),
+// e.g. with embed.FS) or within a dedicated ELF section (see FromELF()).
+//
+// The package is architecture independent, manifests are applied to
+// execution contexts with monitor.LoadManifest() and to their supervision
+// with Policy().
+package manifest
+
+import (
+	"bytes"
+	"debug/elf"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/usbarmory/GoTEE/monitor/supervisor"
+)
+
+// Section is the ELF section name holding an embedded manifest (see
+// FromELF()).
+const Section = ".gotee.manifest"
+
+// Schema is the JSON Schema of the manifest format, meant for external
+// tooling. Manifests are validated with Validate(), which also checks
+// constraints which span multiple fields and are not expressed in the schema
+// (e.g. the entry point must be within memory).
+//
+//go:embed schema.json
+var Schema []byte
+
+// Privilege levels
+const (
+	// ModeUser selects the User privilege level (RISC-V only).
+	ModeUser = "user"
+	// ModeSupervisor selects the Supervisor privilege level (RISC-V only).
+	ModeSupervisor = "supervisor"
+)
+
+// Restart policies
+const (
+	RestartNever     = "never"
+	RestartOnFailure = "on-failure"
+	RestartAlways    = "always"
+)
+
+// Syscalls is the list of system call names which can be allowed to secure
+// execution contexts, "rpc" covers syscall.Call() while "serve" covers
+// syscall.Serve().
+var Syscalls = []string{
+	"exit",
+	"write",
+	"nanotime",
+	"getrandom",
+	"rpc",
+	"serve",
+}
+
+// name and service format
+var nameFormat = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_.-]*$`)
+
+// Address represents a physical address or size, it can be encoded either as
+// a JSON number or as a string (e.g. "0x9c000000").
+type Address uint64
+
+// UnmarshalJSON decodes a JSON number or string into an address.
+func (a *Address) UnmarshalJSON(data []byte) (err error) {
+	var s string
+
+	if err = json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+
+	v, err := strconv.ParseUint(s, 0, 64)
+
+	if err != nil {
+		return fmt.Errorf("invalid address %s", data)
+	}
+
+	*a = Address(v)
+
+	return
+}
+
+// Memory represents the execution context memory region.
+type Memory struct {
+	// Start is the region physical start address
+	Start Address `json:"start"`
+	// Size is the region size
+	Size Address `json:"size"`
+}
+
+// Restart represents the applet restart policy (see supervisor.Policy).
+type Restart struct {
+	// Policy is the restart policy (RestartNever, RestartOnFailure,
+	// RestartAlways), RestartNever when empty.
+	Policy string `json:"policy,omitempty"`
+
+	// Backoff is the delay before a restart (e.g. "100ms")
+	Backoff string `json:"backoff,omitempty"`
+	// MaxBackoff is the maximum delay before a restart
+	MaxBackoff string `json:"max_backoff,omitempty"`
+
+	// MaxRestarts, if not zero, is the maximum number of restarts within
+	// Window.
+	MaxRestarts int `json:"max_restarts,omitempty"`
+	// Window is the interval over which MaxRestarts is enforced
+	Window string `json:"window,omitempty"`
+}
+
+// Manifest represents an applet manifest.
+type Manifest struct {
+	// Name is the applet name
+	Name string `json:"name"`
+	// UUID is the applet UUID in canonical string form
+	UUID string `json:"uuid,omitempty"`
+
+	// Secure is the applet security state
+	Secure bool `json:"secure"`
+	// Mode is the applet privilege level (ModeUser, ModeSupervisor), it is
+	// only supported on RISC-V where it defaults to ModeSupervisor.
+	Mode string `json:"mode,omitempty"`
+
+	// Entry, if not zero, overrides the executable image entry point.
+	Entry Address `json:"entry,omitempty"`
+	// Memory is the applet memory region
+	Memory Memory `json:"memory"`
+
+	// Syscalls, if not empty, restricts the system calls allowed to a
+	// secure applet (see Syscalls).
+	Syscalls []string `json:"syscalls,omitempty"`
+
+	// Services is the list of RPC services served by a secure applet
+	// (see monitor.Bridge.Add()).
+	Services []string `json:"services,omitempty"`
+	// Allow is the list of RPC methods patterns allowed to a non-secure
+	// applet (see monitor.Bridge.Allow()).
+	Allow []string `json:"allow,omitempty"`
+
+	// Deterministic enables deterministic execution of the applet (see
+	// monitor.ExecCtx Deterministic field).
+	Deterministic bool `json:"deterministic,omitempty"`
+
+	// Restart is the applet restart policy
+	Restart Restart `json:"restart"`
+}
+
+// Parse decodes and validates a JSON manifest, unknown fields are rejected.
+func Parse(data []byte) (m *Manifest, err error) {
+	m = &Manifest{}
+
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+
+	if err = dec.Decode(m); err != nil {
+		return nil, fmt.Errorf("invalid manifest, %v", err)
+	}
+
+	if dec.More() {
+		return nil, errors.New("invalid manifest, trailing data")
+	}
+
+	if err = m.Validate(); err != nil {
+		return nil, err
+	}
+
+	return
+}
+
+// Open reads and parses a manifest from the argument file system (e.g. an
+// embed.FS holding applet images and their manifests).
+func Open(fsys fs.FS, name string) (m *Manifest, err error) {
+	data, err := fs.ReadFile(fsys, name)
+
+	if err != nil {
+		return
+	}
+
+	return Parse(data)
+}
+
+// FromELF reads and parses a manifest embedded in the argument applet ELF
+// image, within the section named Section.
+func FromELF(f *elf.File) (m *Manifest, err error) {
+	s := f.Section(Section)
+
+	if s == nil {
+		return nil, fmt.Errorf("missing %s section", Section)
+	}
+
+	data, err := s.Data()
+
+	if err != nil {
+		return
+	}
+
+	// sections might be padded
+	return Parse(bytes.TrimRight(data, "\x00"))
+}
+
+// ParseUUID decodes a UUID in canonical string form
+// (e.g. "8aaaf200-2450-11e4-abe2-0002a5d5c51b").
+func ParseUUID(s string) (u [16]byte, err error) {
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+
+	buf, err := hex.DecodeString(strings.ReplaceAll(s, "-", ""))
+
+	if err != nil {
+		return u, fmt.Errorf("invalid UUID %q", s)
+	}
+
+	copy(u[:], buf)
+
+	return
+}
+
+func validDuration(field string, s string) (err error) {
+	if d, err := parseDuration(s); err != nil || d < 0 {
+		return fmt.Errorf("invalid %s %q", field, s)
+	}
+
+	return
+}
+
+// Validate verifies the manifest against the format constraints.
+func (m *Manifest) Validate() (err error) {
+	if !nameFormat.MatchString(m.Name) {
+		return fmt.Errorf("invalid name %q", m.Name)
+	}
+
+	if m.UUID != "" {
+		if _, err = ParseUUID(m.UUID); err != nil {
+			return
+		}
+	}
+
+	switch m.Mode {
+	case "", ModeUser, ModeSupervisor:
+	default:
+		return fmt.Errorf("invalid mode %q", m.Mode)
+	}
+
+	if m.Memory.Size == 0 {
+		return errors.New("invalid memory size")
+	}
+
+	if m.Memory.Start+m.Memory.Size < m.Memory.Start {
+		return errors.New("invalid memory range")
+	}
+
+	if m.Entry != 0 && (m.Entry < m.Memory.Start || m.Entry >= m.Memory.Start+m.Memory.Size) {
+		return fmt.Errorf("entry %#x outside memory", uint64(m.Entry))
+	}
+
+	for _, s := range m.Syscalls {
+		if !slices.Contains(Syscalls, s) {
+			return fmt.Errorf("invalid syscall %q", s)
+		}
+	}
+
+	for _, s := range m.Services {
+		if !nameFormat.MatchString(s) || strings.Contains(s, ".") {
+			return fmt.Errorf("invalid service %q", s)
+		}
+	}
+
+	for _, p := range m.Allow {
+		if p == "" {
+			return errors.New("invalid empty allow pattern")
+		}
+	}
+
+	if !m.Secure && (len(m.Syscalls) > 0 || len(m.Services) > 0) {
+		return errors.New("syscalls and services are only supported on secure applets")
+	}
+
+	if m.Secure && len(m.Allow) > 0 {
+		return errors.New("allow is only supported on non-secure applets")
+	}
+
+	r := m.Restart
+
+	switch r.Policy {
+	case "", RestartNever, RestartOnFailure, RestartAlways:
+	default:
+		return fmt.Errorf("invalid restart policy %q", r.Policy)
+	}
+
+	if r.MaxRestarts < 0 {
+		return fmt.Errorf("invalid max_restarts %d", r.MaxRestarts)
+	}
+
+	if err = validDuration("backoff", r.Backoff); err != nil {
+		return
+	}
+
+	if err = validDuration("max_backoff", r.MaxBackoff); err != nil {
+		return
+	}
+
+	if err = validDuration("window", r.Window); err != nil {
+		return
+	}
+
+	if r.MaxRestarts > 0 && r.Window == "" {
+		return errors.New("max_restarts requires window")
+	}
+
+	return
+}
+
+// Allowed returns whether the manifest allows the argument system call name
+// (see Syscalls).
+func (m *Manifest) Allowed(syscall string) bool {
+	return len(m.Syscalls) == 0 || slices.Contains(m.Syscalls, syscall)
+}
+
+// Policy returns the manifest restart policy for applet supervision (see
+// supervisor.Supervisor.Add()).
+func (m *Manifest) Policy() (p supervisor.Policy, err error) {
+	if err = m.Validate(); err != nil {
+		return
+	}
+
+	r := m.Restart
+
+	switch r.Policy {
+	case "", RestartNever:
+		p.Restart = supervisor.RestartNever
+	case RestartOnFailure:
+		p.Restart = supervisor.RestartOnFailure
+	case RestartAlways:
+		p.Restart = supervisor.RestartAlways
+	}
+
+	// durations are validated above
+	p.Backoff, _ = parseDuration(r.Backoff)
+	p.MaxBackoff, _ = parseDuration(r.MaxBackoff)
+	p.Window, _ = parseDuration(r.Window)
+	p.MaxRestarts = r.MaxRestarts
+
+	return
+}
+
+func parseDuration(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	return time.ParseDuration(s)
+}
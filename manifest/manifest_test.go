@@ -0,0 +1,404 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package manifest
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"encoding/json"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/usbarmory/GoTEE/monitor/supervisor"
+)
+
+const validManifest = `{
+	"name": "ta",
+	"uuid": "8aaaf200-2450-11e4-abe2-0002a5d5c51b",
+	"secure": true,
+	"mode": "supervisor",
+	"entry": "0x9c010000",
+	"memory": {"start": "0x9c000000", "size": 33554432},
+	"syscalls": ["exit", "write", "rpc"],
+	"services": ["RPC", "Echo_v2"],
+	"deterministic": true,
+	"restart": {
+		"policy": "on-failure",
+		"backoff": "100ms",
+		"max_backoff": "5s",
+		"max_restarts": 3,
+		"window": "1m"
+	}
+}`
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		err      string
+	}{
+		{"valid", validManifest, ""},
+		{"minimal", `{"name": "os", "memory": {"start": 0, "size": 4096}}`, ""},
+		{"non-secure allow", `{"name": "os", "memory": {"start": 0, "size": 1}, "allow": ["RPC.*"]}`, ""},
+		{"octal size", `{"name": "os", "memory": {"start": 0, "size": "0o10"}}`, ""},
+		{"unknown field", `{"name": "os", "memory": {"start": 0, "size": 1}, "heap": 1}`, "unknown field"},
+		{"unknown memory field", `{"name": "os", "memory": {"start": 0, "size": 1, "end": 1}}`, "unknown field"},
+		{"trailing data", `{"name": "os", "memory": {"start": 0, "size": 1}} {}`, "trailing data"},
+		{"malformed", `{"name": "os",`, "invalid manifest"},
+		{"bad address", `{"name": "os", "memory": {"start": "ram", "size": 1}}`, "invalid address"},
+		{"negative address", `{"name": "os", "memory": {"start": -1, "size": 1}}`, "invalid address"},
+		{"bad name", `{"name": "1st", "memory": {"start": 0, "size": 1}}`, "invalid name"},
+		{"missing name", `{"memory": {"start": 0, "size": 1}}`, "invalid name"},
+		{"bad uuid", `{"name": "os", "uuid": "8aaaf200-2450-11e4-abe2-0002a5d5c51", "memory": {"start": 0, "size": 1}}`, "invalid UUID"},
+		{"bad uuid hex", `{"name": "os", "uuid": "8aaaf200-2450-11e4-abe2-0002a5d5c51g", "memory": {"start": 0, "size": 1}}`, "invalid UUID"},
+		{"bad uuid separator", `{"name": "os", "uuid": "8aaaf200x2450-11e4-abe2-0002a5d5c51b", "memory": {"start": 0, "size": 1}}`, "invalid UUID"},
+		{"bad mode", `{"name": "os", "mode": "machine", "memory": {"start": 0, "size": 1}}`, "invalid mode"},
+		{"missing memory", `{"name": "os"}`, "invalid memory size"},
+		{"memory overflow", `{"name": "os", "memory": {"start": "0xffffffffffffffff", "size": 2}}`, "invalid memory range"},
+		{"entry outside memory", `{"name": "os", "entry": 4096, "memory": {"start": 0, "size": 4096}}`, "outside memory"},
+		{"bad syscall", `{"name": "ta", "secure": true, "memory": {"start": 0, "size": 1}, "syscalls": ["fork"]}`, "invalid syscall"},
+		{"bad service", `{"name": "ta", "secure": true, "memory": {"start": 0, "size": 1}, "services": ["RPC.Echo"]}`, "invalid service"},
+		{"empty allow", `{"name": "os", "memory": {"start": 0, "size": 1}, "allow": [""]}`, "empty allow"},
+		{"non-secure syscalls", `{"name": "os", "memory": {"start": 0, "size": 1}, "syscalls": ["exit"]}`, "only supported on secure"},
+		{"non-secure services", `{"name": "os", "memory": {"start": 0, "size": 1}, "services": ["RPC"]}`, "only supported on secure"},
+		{"secure allow", `{"name": "ta", "secure": true, "memory": {"start": 0, "size": 1}, "allow": ["RPC.*"]}`, "only supported on non-secure"},
+		{"bad policy", `{"name": "os", "memory": {"start": 0, "size": 1}, "restart": {"policy": "sometimes"}}`, "invalid restart policy"},
+		{"bad backoff", `{"name": "os", "memory": {"start": 0, "size": 1}, "restart": {"backoff": "1 s"}}`, "invalid backoff"},
+		{"negative max_backoff", `{"name": "os", "memory": {"start": 0, "size": 1}, "restart": {"max_backoff": "-1s"}}`, "invalid max_backoff"},
+		{"negative max_restarts", `{"name": "os", "memory": {"start": 0, "size": 1}, "restart": {"max_restarts": -1, "window": "1s"}}`, "invalid max_restarts"},
+		{"max_restarts without window", `{"name": "os", "memory": {"start": 0, "size": 1}, "restart": {"max_restarts": 1}}`, "requires window"},
+	}
+
+	for _, tt := range tests {
+		_, err := Parse([]byte(tt.manifest))
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error, %v", tt.name, err)
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: expected error", tt.name)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: error %q, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestParseFields(t *testing.T) {
+	m, err := Parse([]byte(validManifest))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Manifest{
+		Name:          "ta",
+		UUID:          "8aaaf200-2450-11e4-abe2-0002a5d5c51b",
+		Secure:        true,
+		Mode:          ModeSupervisor,
+		Entry:         0x9c010000,
+		Memory:        Memory{Start: 0x9c000000, Size: 0x2000000},
+		Syscalls:      []string{"exit", "write", "rpc"},
+		Services:      []string{"RPC", "Echo_v2"},
+		Deterministic: true,
+		Restart: Restart{
+			Policy:      RestartOnFailure,
+			Backoff:     "100ms",
+			MaxBackoff:  "5s",
+			MaxRestarts: 3,
+			Window:      "1m",
+		},
+	}
+
+	if !reflect.DeepEqual(m, want) {
+		t.Errorf("manifest = %+v, want %+v", m, want)
+	}
+
+	for _, s := range Syscalls {
+		if allowed := slices.Contains(want.Syscalls, s); m.Allowed(s) != allowed {
+			t.Errorf("Allowed(%q) = %v, want %v", s, !allowed, allowed)
+		}
+	}
+
+	if !(&Manifest{}).Allowed("getrandom") {
+		t.Error("empty syscalls must allow all system calls")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	tests := []struct {
+		data string
+		want Address
+		err  bool
+	}{
+		{`4096`, 4096, false},
+		{`"4096"`, 4096, false},
+		{`"0x9c000000"`, 0x9c000000, false},
+		{`"0X9C000000"`, 0x9c000000, false},
+		{`"0b101"`, 5, false},
+		{`"0o17"`, 15, false},
+		{`"017"`, 15, false},
+		{`"0xffffffffffffffff"`, 1<<64 - 1, false},
+		{`"0x10000000000000000"`, 0, true},
+		{`1.5`, 0, true},
+		{`-1`, 0, true},
+		{`""`, 0, true},
+		{`"ram"`, 0, true},
+		{`true`, 0, true},
+	}
+
+	for _, tt := range tests {
+		var a Address
+
+		err := json.Unmarshal([]byte(tt.data), &a)
+
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error", tt.data)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error, %v", tt.data, err)
+		} else if a != tt.want {
+			t.Errorf("%s: address = %#x, want %#x", tt.data, uint64(a), uint64(tt.want))
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		restart Restart
+		want    supervisor.Policy
+	}{
+		{
+			Restart{},
+			supervisor.Policy{Restart: supervisor.RestartNever},
+		},
+		{
+			Restart{Policy: RestartNever},
+			supervisor.Policy{Restart: supervisor.RestartNever},
+		},
+		{
+			Restart{Policy: RestartAlways, Backoff: "10ms"},
+			supervisor.Policy{Restart: supervisor.RestartAlways, Backoff: 10 * time.Millisecond},
+		},
+		{
+			Restart{Policy: RestartOnFailure, Backoff: "100ms", MaxBackoff: "5s", MaxRestarts: 3, Window: "1m"},
+			supervisor.Policy{
+				Restart:     supervisor.RestartOnFailure,
+				Backoff:     100 * time.Millisecond,
+				MaxBackoff:  5 * time.Second,
+				MaxRestarts: 3,
+				Window:      time.Minute,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		m := &Manifest{Name: "os", Memory: Memory{Size: 1}, Restart: tt.restart}
+
+		p, err := m.Policy()
+
+		if err != nil {
+			t.Errorf("%+v: unexpected error, %v", tt.restart, err)
+		} else if p != tt.want {
+			t.Errorf("%+v: policy = %+v, want %+v", tt.restart, p, tt.want)
+		}
+	}
+
+	m := &Manifest{Name: "os", Memory: Memory{Size: 1}, Restart: Restart{MaxRestarts: 1}}
+
+	if _, err := m.Policy(); err == nil {
+		t.Error("expected error on invalid manifest")
+	}
+}
+
+func TestOpen(t *testing.T) {
+	fsys := fstest.MapFS{
+		"ta.json": {Data: []byte(validManifest)},
+	}
+
+	if m, err := Open(fsys, "ta.json"); err != nil || m.Name != "ta" {
+		t.Errorf("manifest = %+v, %v", m, err)
+	}
+
+	if _, err := Open(fsys, "os.json"); err == nil {
+		t.Error("expected error on missing file")
+	}
+}
+
+// buildELF returns a minimal ELF64 image holding a single section with the
+// argument name and contents.
+func buildELF(name string, data []byte) []byte {
+	var buf bytes.Buffer
+
+	shstrtab := []byte("\x00.shstrtab\x00" + name + "\x00")
+	ehsize := binary.Size(elf.Header64{})
+	shsize := binary.Size(elf.Section64{})
+
+	strOff := uint64(ehsize)
+	dataOff := strOff + uint64(len(shstrtab))
+	shOff := (dataOff + uint64(len(data)) + 7) &^ 7
+
+	hdr := elf.Header64{
+		Type:      uint16(elf.ET_EXEC),
+		Machine:   uint16(elf.EM_RISCV),
+		Version:   uint32(elf.EV_CURRENT),
+		Shoff:     shOff,
+		Ehsize:    uint16(ehsize),
+		Shentsize: uint16(shsize),
+		Shnum:     3,
+		Shstrndx:  1,
+	}
+
+	copy(hdr.Ident[:], elf.ELFMAG)
+	hdr.Ident[elf.EI_CLASS] = byte(elf.ELFCLASS64)
+	hdr.Ident[elf.EI_DATA] = byte(elf.ELFDATA2LSB)
+	hdr.Ident[elf.EI_VERSION] = byte(elf.EV_CURRENT)
+
+	sections := []elf.Section64{
+		{},
+		{
+			Name:      1,
+			Type:      uint32(elf.SHT_STRTAB),
+			Off:       strOff,
+			Size:      uint64(len(shstrtab)),
+			Addralign: 1,
+		},
+		{
+			Name:      uint32(len("\x00.shstrtab\x00")),
+			Type:      uint32(elf.SHT_PROGBITS),
+			Off:       dataOff,
+			Size:      uint64(len(data)),
+			Addralign: 1,
+		},
+	}
+
+	binary.Write(&buf, binary.LittleEndian, hdr)
+	buf.Write(shstrtab)
+	buf.Write(data)
+	buf.Write(make([]byte, shOff-uint64(buf.Len())))
+	binary.Write(&buf, binary.LittleEndian, sections)
+
+	return buf.Bytes()
+}
+
+func TestFromELF(t *testing.T) {
+	tests := []struct {
+		name    string
+		section string
+		data    []byte
+		err     string
+	}{
+		{"embedded", Section, []byte(validManifest), ""},
+		{"padded", Section, append([]byte(validManifest), make([]byte, 13)...), ""},
+		{"missing section", ".data", []byte(validManifest), "missing"},
+		{"inner padding", Section, []byte(validManifest + "\x00 {}"), "invalid manifest"},
+	}
+
+	for _, tt := range tests {
+		f, err := elf.NewFile(bytes.NewReader(buildELF(tt.section, tt.data)))
+
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+
+		m, err := FromELF(f)
+
+		switch {
+		case tt.err == "" && err != nil:
+			t.Errorf("%s: unexpected error, %v", tt.name, err)
+		case tt.err == "" && m.Name != "ta":
+			t.Errorf("%s: name = %q, want %q", tt.name, m.Name, "ta")
+		case tt.err != "" && err == nil:
+			t.Errorf("%s: expected error", tt.name)
+		case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+			t.Errorf("%s: error %q, want %q", tt.name, err, tt.err)
+		}
+	}
+}
+
+// jsonFields returns the JSON field names of the argument struct type.
+func jsonFields(t reflect.Type) (fields []string) {
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+
+	slices.Sort(fields)
+
+	return
+}
+
+// schemaFields returns the property names of the argument schema object.
+func schemaFields(t *testing.T, object map[string]any) (fields []string) {
+	properties, ok := object["properties"].(map[string]any)
+
+	if !ok {
+		t.Fatal("schema object without properties")
+	}
+
+	for name := range properties {
+		fields = append(fields, name)
+	}
+
+	slices.Sort(fields)
+
+	return
+}
+
+func TestSchema(t *testing.T) {
+	var schema map[string]any
+
+	if !json.Valid(Schema) {
+		t.Fatal("invalid schema JSON")
+	}
+
+	if err := json.Unmarshal(Schema, &schema); err != nil {
+		t.Fatal(err)
+	}
+
+	properties := schema["properties"].(map[string]any)
+
+	tests := []struct {
+		name   string
+		object map[string]any
+		typ    reflect.Type
+	}{
+		{"manifest", schema, reflect.TypeOf(Manifest{})},
+		{"memory", properties["memory"].(map[string]any), reflect.TypeOf(Memory{})},
+		{"restart", properties["restart"].(map[string]any), reflect.TypeOf(Restart{})},
+	}
+
+	for _, tt := range tests {
+		if got, want := schemaFields(t, tt.object), jsonFields(tt.typ); !slices.Equal(got, want) {
+			t.Errorf("%s: schema properties %v, want %v", tt.name, got, want)
+		}
+
+		if tt.object["additionalProperties"] != false {
+			t.Errorf("%s: schema allows unknown fields", tt.name)
+		}
+	}
+
+	syscalls := properties["syscalls"].(map[string]any)["items"].(map[string]any)["enum"].([]any)
+
+	if len(syscalls) != len(Syscalls) {
+		t.Fatalf("schema syscalls %v, want %v", syscalls, Syscalls)
+	}
+
+	for i, s := range syscalls {
+		if s != Syscalls[i] {
+			t.Errorf("schema syscall %v, want %s", s, Syscalls[i])
+		}
+	}
+}
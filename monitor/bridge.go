@@ -0,0 +1,281 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"sync"
+
+	"github.com/usbarmory/GoTEE/syscall"
+)
+
+// queue represents the RPC requests forwarded to a secure execution context
+// (see Bridge).
+type queue struct {
+	sync.Mutex
+
+	// pending requests
+	req chan []byte
+	// pending responses
+	res chan []byte
+	// partially read request
+	in []byte
+	// request read by the service and awaiting its response, accessed
+	// only by the service execution context
+	outstanding bool
+	// response to an abandoned request is pending
+	abandoned bool
+}
+
+// Bridge represents a Normal World to Trusted Applet RPC bridge, JSON-RPC
+// requests issued by non-secure execution contexts are forwarded to the RPC
+// services of secure execution contexts (see syscall.Serve()), and their
+// responses returned.
+//
+// Requests are routed by service name, the first component of the JSON-RPC
+// method ("Service.Method"), and subject to access control rules (see
+// Allow()). Rejected requests are returned a JSON-RPC error response.
+//
+// The shared memory interface is exposed through a secure monitor call on ARM
+// (see Register()) or through the GoTEE vendor SBI extension on RISC-V (see
+// sbi.Bridge).
+type Bridge struct {
+	sync.Mutex
+
+	// secure execution contexts indexed by service
+	services map[string]*ExecCtx
+	// allowed method patterns indexed by non-secure execution context
+	acl map[*ExecCtx][]string
+}
+
+// NewBridge returns a Normal World to Trusted Applet RPC bridge without
+// services.
+func NewBridge() *Bridge {
+	return &Bridge{
+		services: make(map[string]*ExecCtx),
+		acl:      make(map[*ExecCtx][]string),
+	}
+}
+
+// Add routes requests for the argument service name to a secure execution
+// context, which is expected to serve them with syscall.Serve().
+func (b *Bridge) Add(service string, applet *ExecCtx) (err error) {
+	if applet == nil || applet.nonSecure() {
+		return errors.New("invalid applet execution context")
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if _, ok := b.services[service]; ok {
+		return fmt.Errorf("service %s already registered", service)
+	}
+
+	if applet.queue == nil {
+		applet.queue = &queue{
+			req: make(chan []byte, 1),
+			res: make(chan []byte, 1),
+		}
+	}
+
+	b.services[service] = applet
+
+	return
+}
+
+// Allow grants a non-secure execution context access to the methods matching
+// the argument patterns (e.g. "RNG.GetRandom", "RNG.*", see path.Match()).
+func (b *Bridge) Allow(ctx *ExecCtx, patterns ...string) (err error) {
+	for _, p := range patterns {
+		if _, err = path.Match(p, ""); err != nil {
+			return
+		}
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	b.acl[ctx] = append(b.acl[ctx], patterns...)
+
+	return
+}
+
+// allowed returns whether a non-secure execution context is allowed to invoke
+// the argument method.
+func (b *Bridge) allowed(ctx *ExecCtx, method string) bool {
+	for _, p := range b.acl[ctx] {
+		if ok, _ := path.Match(p, method); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reject returns a JSON-RPC error response for the argument request.
+func reject(id *json.RawMessage, reason string) []byte {
+	res, _ := json.Marshal(struct {
+		ID     *json.RawMessage `json:"id"`
+		Result any              `json:"result"`
+		Error  any              `json:"error"`
+	}{id, nil, reason})
+
+	return append(res, '\n')
+}
+
+// Call forwards a JSON-RPC request, issued by a non-secure execution context,
+// to the relevant secure execution context and returns its response.
+//
+// The function blocks until the request is served or until the calling
+// execution context is stopped (see Stop()) or canceled (see RunContext()).
+func (b *Bridge) Call(ctx *ExecCtx, req []byte) (res []byte, err error) {
+	var r struct {
+		Method string           `json:"method"`
+		ID     *json.RawMessage `json:"id"`
+	}
+
+	if err = json.Unmarshal(req, &r); err != nil {
+		return
+	}
+
+	service, _, _ := strings.Cut(r.Method, ".")
+
+	b.Lock()
+	applet, ok := b.services[service]
+	allowed := b.allowed(ctx, r.Method)
+	b.Unlock()
+
+	switch {
+	case !ok:
+		return reject(r.ID, "rpc: can't find service "+r.Method), nil
+	case !allowed:
+		return reject(r.ID, "rpc: access denied to "+r.Method), nil
+	}
+
+	q := applet.queue
+
+	q.Lock()
+	defer q.Unlock()
+
+	// discard the response to an abandoned request
+	if q.abandoned {
+		if _, ok := ctx.receive(q.res); !ok {
+			return nil, errors.New("execution context stopped")
+		}
+
+		q.abandoned = false
+	}
+
+	if !ctx.send(q.req, req) {
+		return nil, errors.New("execution context stopped")
+	}
+
+	if res, ok = ctx.receive(q.res); !ok {
+		q.abandoned = true
+		return nil, errors.New("execution context stopped")
+	}
+
+	return
+}
+
+// Handle serves a JSON-RPC request held in the non-secure execution context
+// memory at the argument address and size (see Call()), the response is
+// written at the same address, up to the argument buffer capacity, and its
+// size is returned.
+func (b *Bridge) Handle(ctx *ExecCtx, addr uint, size int, capacity int) (n int, err error) {
+	if size < 0 || capacity < 0 || size > capacity {
+		return 0, errors.New("invalid buffer size")
+	}
+
+	off, err := ctx.MemoryRegion(addr, capacity)
+
+	if err != nil {
+		return
+	}
+
+	req := make([]byte, size)
+	ctx.Memory.Read(ctx.Memory.Start(), off, req)
+
+	res, err := b.Call(ctx, req)
+
+	if err != nil {
+		return
+	}
+
+	if n = len(res); n > capacity {
+		return n, errors.New("response exceeds buffer capacity")
+	}
+
+	ctx.Poke(off, res)
+
+	return
+}
+
+// serve handles syscall.Serve() requests raised by a secure execution
+// context, forwarded requests are returned to its memory while responses are
+// returned to the bridge caller.
+//
+// Requests are awaited, and responses delivered, blocking the execution
+// context until the bridge caller is ready or until the execution context is
+// stopped (see Stop()) or canceled (see RunContext()). A response without an
+// outstanding request raises an error.
+func (ctx *ExecCtx) serve(num uint) (err error) {
+	q := ctx.queue
+
+	if q == nil {
+		return errors.New("execution context is not a bridge service")
+	}
+
+	off, n, err := ctx.TransferRegion()
+
+	if err != nil {
+		return
+	}
+
+	switch num {
+	case syscall.SYS_RPC_SRV_REQ:
+		if len(q.in) == 0 {
+			req, ok := ctx.receive(q.req)
+
+			if !ok {
+				// the request is issued again on resume
+				ctx.rewind()
+				return
+			}
+
+			q.in = req
+			q.outstanding = true
+		}
+
+		n = min(n, len(q.in))
+
+		ctx.Poke(off, q.in[0:n])
+		ctx.Ret(n)
+
+		q.in = q.in[n:]
+	case syscall.SYS_RPC_SRV_RES:
+		if !q.outstanding {
+			return errors.New("unsolicited RPC response")
+		}
+
+		buf := make([]byte, n)
+		ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+		if !ctx.send(q.res, buf) {
+			// the response is issued again on resume
+			ctx.rewind()
+			return
+		}
+
+		q.outstanding = false
+	}
+
+	return
+}
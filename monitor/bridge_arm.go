@@ -0,0 +1,41 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/GoTEE/smccc"
+)
+
+// BRIDGE_CALL is the yielding secure monitor call, within the Trusted
+// Application Calls range, which forwards a JSON-RPC request to the Bridge.
+//
+// The request is held in shared memory, addressed physically, at R1 with its
+// size in R2 and buffer capacity in R3. The response size is returned in R1.
+const BRIDGE_CALL = 0x30000000
+
+// Register adds the bridge secure monitor call to the argument dispatcher.
+func (b *Bridge) Register(d *smccc.Dispatcher) error {
+	return d.Register(BRIDGE_CALL, b.smc)
+}
+
+func (b *Bridge) smc(call *smccc.Call) error {
+	ctx := call.Context.(*ExecCtx)
+
+	addr := uint(call.Regs[1])
+	size := int(call.Regs[2])
+	capacity := int(call.Regs[3])
+
+	n, err := b.Handle(ctx, addr, size, capacity)
+
+	if err != nil {
+		call.Return(smccc.INVALID_PARAMETER, int64(n))
+		return nil
+	}
+
+	call.Return(smccc.SUCCESS, int64(n))
+
+	return nil
+}
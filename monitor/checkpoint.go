@@ -0,0 +1,226 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/usbarmory/tamago/dma"
+)
+
+// CheckpointVersion is the execution context checkpoint format version.
+const CheckpointVersion = 1
+
+// checkpoint limits
+const (
+	checkpointMagic   = 0x45455447 // GTEE
+	maxCheckpointRegs = 256
+	maxCheckpointBuf  = 1 << 24
+)
+
+// checkpointHeader represents the checkpoint format header, all fields are
+// little-endian encoded.
+type checkpointHeader struct {
+	Magic   uint32
+	Version uint32
+	Arch    uint32
+	Secure  uint32
+	Mode    uint32
+}
+
+func writeSlice(w io.Writer, s any, n int) (err error) {
+	if err = binary.Write(w, binary.LittleEndian, uint32(n)); err != nil {
+		return
+	}
+
+	return binary.Write(w, binary.LittleEndian, s)
+}
+
+func readLen(r io.Reader, max uint32) (n uint32, err error) {
+	if err = binary.Read(r, binary.LittleEndian, &n); err != nil {
+		return
+	}
+
+	if n > max {
+		return 0, fmt.Errorf("invalid checkpoint length %d", n)
+	}
+
+	return
+}
+
+func readRegs(r io.Reader) (s []uint64, err error) {
+	n, err := readLen(r, maxCheckpointRegs)
+
+	if err != nil {
+		return
+	}
+
+	s = make([]uint64, n)
+	err = binary.Read(r, binary.LittleEndian, s)
+
+	return
+}
+
+func readBuf(r io.Reader) (buf []byte, err error) {
+	n, err := readLen(r, maxCheckpointBuf)
+
+	if err != nil {
+		return
+	}
+
+	buf = make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+
+	return
+}
+
+// Checkpoint serializes the execution context state, including registers,
+// floating-point state, Read()/Write() buffers and Memory contents, to the
+// argument writer in a versioned binary format (see CheckpointVersion).
+//
+// The execution context must not be running (e.g. it can be invoked from its
+// Handler or after Run() returns). Configuration fields (e.g. Handler, Server,
+// PageTable, Shadow) are not serialized.
+func (ctx *ExecCtx) Checkpoint(w io.Writer) (err error) {
+	mode, secure := ctx.loadParams()
+
+	hdr := checkpointHeader{
+		Magic:   checkpointMagic,
+		Version: CheckpointVersion,
+		Arch:    checkpointArch,
+		Mode:    uint32(mode),
+	}
+
+	if secure {
+		hdr.Secure = 1
+	}
+
+	if err = binary.Write(w, binary.LittleEndian, &hdr); err != nil {
+		return
+	}
+
+	state := ctx.state()
+	trap := ctx.trapState()
+
+	if err = writeSlice(w, state, len(state)); err != nil {
+		return
+	}
+
+	if err = writeSlice(w, trap, len(trap)); err != nil {
+		return
+	}
+
+	if err = writeSlice(w, ctx.in, len(ctx.in)); err != nil {
+		return
+	}
+
+	if err = writeSlice(w, ctx.out, len(ctx.out)); err != nil {
+		return
+	}
+
+	start := uint64(ctx.Memory.Start())
+	size := uint64(ctx.Memory.Size())
+
+	if err = binary.Write(w, binary.LittleEndian, []uint64{start, size}); err != nil {
+		return
+	}
+
+	_, err = w.Write(mem(ctx.Memory.Start(), int(size)))
+
+	return
+}
+
+// Restore deserializes an execution context checkpoint (see Checkpoint()),
+// recreating an equivalent execution context, as initialized by Load(), with
+// a new Memory region at the checkpoint memory address.
+//
+// The memory region contents are overwritten with the checkpoint ones, after
+// all other checkpoint fields are validated, its physical memory must
+// therefore not be in use. Configuration fields (e.g.
+// Handler, Server, PageTable, Shadow) are set to their Load() defaults and must
+// be overridden by the caller as required.
+func Restore(r io.Reader) (ctx *ExecCtx, err error) {
+	var hdr checkpointHeader
+
+	if err = binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return
+	}
+
+	switch {
+	case hdr.Magic != checkpointMagic:
+		return nil, errors.New("invalid checkpoint")
+	case hdr.Version != CheckpointVersion:
+		return nil, fmt.Errorf("unsupported checkpoint version %d", hdr.Version)
+	case hdr.Arch != checkpointArch:
+		return nil, errors.New("checkpoint architecture mismatch")
+	}
+
+	state, err := readRegs(r)
+
+	if err != nil {
+		return
+	}
+
+	trap, err := readRegs(r)
+
+	if err != nil {
+		return
+	}
+
+	in, err := readBuf(r)
+
+	if err != nil {
+		return
+	}
+
+	out, err := readBuf(r)
+
+	if err != nil {
+		return
+	}
+
+	region := make([]uint64, 2)
+
+	if err = binary.Read(r, binary.LittleEndian, region); err != nil {
+		return
+	}
+
+	if region[1] == 0 || region[1] > math.MaxInt || region[0]+region[1] < region[0] ||
+		uint64(uint(region[0])) != region[0] || uint64(uint(region[0]+region[1]-1)) != region[0]+region[1]-1 {
+		return nil, errors.New("invalid checkpoint memory region")
+	}
+
+	start := uint(region[0])
+	size := int(region[1])
+
+	m, err := dma.NewRegion(start, size, false)
+
+	if err != nil {
+		return
+	}
+
+	if ctx, err = restoreLoad(m, int(hdr.Mode), hdr.Secure == 1); err != nil {
+		return
+	}
+
+	if !ctx.setState(state) || !ctx.setTrapState(trap) {
+		return nil, errors.New("invalid checkpoint state")
+	}
+
+	// memory is overwritten only once all state is validated
+	if _, err = io.ReadFull(r, mem(start, size)); err != nil {
+		return nil, err
+	}
+
+	ctx.in = in
+	ctx.out = out
+
+	return
+}
@@ -0,0 +1,40 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/tamago/dma"
+)
+
+// checkpoint architecture identifier
+const checkpointArch = 40 // EM_ARM
+
+// loadParams returns the execution context Load() parameters.
+func (ctx *ExecCtx) loadParams() (mode int, secure bool) {
+	return 0, !ctx.ns
+}
+
+// restoreLoad initializes a restored execution context.
+func restoreLoad(mem *dma.Region, _ int, secure bool) (*ExecCtx, error) {
+	return Load(0, mem, secure)
+}
+
+// trapState returns the execution context exception state.
+func (ctx *ExecCtx) trapState() []uint64 {
+	return []uint64{uint64(ctx.ExceptionVector)}
+}
+
+// setTrapState sets the execution context exception state, as returned by
+// trapState().
+func (ctx *ExecCtx) setTrapState(s []uint64) bool {
+	if len(s) != 1 {
+		return false
+	}
+
+	ctx.ExceptionVector = int(s[0])
+
+	return true
+}
@@ -0,0 +1,41 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/tamago/dma"
+)
+
+// checkpoint architecture identifier
+const checkpointArch = 243 // EM_RISCV
+
+// loadParams returns the execution context LoadMode() parameters.
+func (ctx *ExecCtx) loadParams() (mode int, secure bool) {
+	return ctx.Privilege(), ctx.secure
+}
+
+// restoreLoad initializes a restored execution context.
+func restoreLoad(mem *dma.Region, mode int, secure bool) (*ExecCtx, error) {
+	return LoadMode(0, mem, mode, secure)
+}
+
+// trapState returns the execution context trap state.
+func (ctx *ExecCtx) trapState() []uint64 {
+	return []uint64{ctx.MEPC, ctx.MTVAL}
+}
+
+// setTrapState sets the execution context trap state, as returned by
+// trapState().
+func (ctx *ExecCtx) setTrapState(s []uint64) bool {
+	if len(s) != 2 {
+		return false
+	}
+
+	ctx.MEPC = s[0]
+	ctx.MTVAL = s[1]
+
+	return true
+}
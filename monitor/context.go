@@ -0,0 +1,161 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// begin prepares the execution context for Run(), returning the channel to
+// be closed once it has stopped running.
+func (ctx *ExecCtx) begin() chan struct{} {
+	mux.Lock()
+	defer mux.Unlock()
+
+	ctx.run = true
+	ctx.halt = make(chan struct{})
+
+	select {
+	case <-ctx.stopped:
+		// previous Run() has returned
+		ctx.stopped = make(chan struct{})
+	default:
+		if ctx.stopped == nil {
+			ctx.stopped = make(chan struct{})
+		}
+	}
+
+	return ctx.stopped
+}
+
+// Done returns a channel which will be closed once execution context has
+// stopped.
+//
+// The function can be invoked before Run(), in which case the channel is
+// closed once the next Run() returns.
+func (ctx *ExecCtx) Done() chan struct{} {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if ctx.stopped == nil {
+		ctx.stopped = make(chan struct{})
+	}
+
+	return ctx.stopped
+}
+
+// Stop stops the execution context.
+//
+// The execution context stops once it yields back to the monitor, handlers
+// blocked waiting on its behalf (e.g. syscall.Serve()) are released.
+func (ctx *ExecCtx) Stop() {
+	mux.Lock()
+	defer mux.Unlock()
+
+	if ctx.run && ctx.halt != nil {
+		close(ctx.halt)
+		ctx.halt = nil
+	}
+
+	ctx.run = false
+}
+
+// released returns the channels which release handlers blocked on behalf of
+// the execution context, when it is stopped or canceled.
+func (ctx *ExecCtx) released() (halt <-chan struct{}, cancel <-chan struct{}) {
+	mux.Lock()
+	defer mux.Unlock()
+
+	return ctx.halt, ctx.cancel
+}
+
+// receive blocks until a buffer is received from the argument channel, ok is
+// false if the execution context is stopped or canceled in the meantime.
+func (ctx *ExecCtx) receive(c <-chan []byte) (buf []byte, ok bool) {
+	halt, cancel := ctx.released()
+
+	select {
+	case buf = <-c:
+		return buf, true
+	case <-halt:
+	case <-cancel:
+	}
+
+	return nil, false
+}
+
+// send blocks until a buffer is sent to the argument channel, ok is false if
+// the execution context is stopped or canceled in the meantime.
+func (ctx *ExecCtx) send(c chan<- []byte, buf []byte) (ok bool) {
+	halt, cancel := ctx.released()
+
+	select {
+	case c <- buf:
+		return true
+	case <-halt:
+	case <-cancel:
+	}
+
+	return false
+}
+
+// canceled returns whether the context passed to RunContext() is done or its
+// deadline has expired.
+func (ctx *ExecCtx) canceled() bool {
+	if !ctx.deadline.IsZero() && !time.Now().Before(ctx.deadline) {
+		return true
+	}
+
+	select {
+	case <-ctx.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+// RunContext starts the execution context, as Run(), until the argument
+// context is canceled or its deadline expires, in which case the returned
+// error wraps the context error (see context.Context.Err()).
+//
+// Handlers blocked on behalf of the execution context (e.g.
+// syscall.Serve()) are released on cancellation.
+//
+// On RISC-V the deadline is enforced with a machine timer interrupt, which
+// preempts the execution context when it expires. On ARM the context
+// cancellation, like Stop(), takes effect once the execution context yields
+// back to the monitor on its next exception.
+func (ctx *ExecCtx) RunContext(c context.Context) (err error) {
+	if err = c.Err(); err != nil {
+		return fmt.Errorf("execution context not started, %w", err)
+	}
+
+	mux.Lock()
+	ctx.cancel = c.Done()
+	ctx.deadline, _ = c.Deadline()
+	mux.Unlock()
+
+	defer func() {
+		mux.Lock()
+		defer mux.Unlock()
+
+		ctx.cancel = nil
+		ctx.deadline = time.Time{}
+	}()
+
+	err = ctx.Run()
+
+	switch cerr := c.Err(); {
+	case cerr == nil:
+		return
+	case err == nil:
+		return fmt.Errorf("execution context stopped, %w", cerr)
+	default:
+		return fmt.Errorf("%w, %w", err, cerr)
+	}
+}
@@ -14,16 +14,20 @@ package monitor
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/rpc"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/usbarmory/tamago/arm"
 	"github.com/usbarmory/tamago/arm/tzc380"
 	"github.com/usbarmory/tamago/dma"
 	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+
+	"github.com/usbarmory/GoTEE/fault"
 )
 
 var (
@@ -124,6 +128,14 @@ type ExecCtx struct {
 	// (see arm.ConfigureMMU).
 	MMU func()
 
+	// PageTable, if not nil, replaces the Trusted OS translation table
+	// while a secure execution context is scheduled, giving it its own
+	// virtual address space (see InitPageTable()).
+	//
+	// A Shadow execution context requires its own PageTable, mapping its
+	// own Memory.
+	PageTable *PageTable
+
 	// Handler, if not nil, handles context switch calls
 	Handler func(ctx *ExecCtx) error
 
@@ -136,13 +148,93 @@ type ExecCtx struct {
 	//
 	// When set Run() will Schedule() the primary and Shadow context for
 	// opportunistic comparison, in case of a mismatch (see Equal) the
-	// primary context Run() raises an error.
+	// primary context Run() raises a LockstepError.
 	Shadow *ExecCtx
 
+	// MemoryCheck configures the optional comparison of primary and
+	// Shadow memory at lockstep.
+	MemoryCheck MemoryCheck
+
+	// Deterministic enables deterministic soft lockstep (see Shadow),
+	// where nondeterministic inputs provided by the monitor are recorded
+	// from the primary execution context and replayed into the Shadow.
+	//
+	// Interrupts caught by the primary context are recorded and the
+	// Shadow is compared only at its next synchronous exception, while
+	// interrupts caught by the Shadow are passed to the primary context
+	// Handler, with the Shadow as argument, and counted as unrecorded
+	// nondeterminism (see LockstepError).
+	//
+	// Direct time or performance counter reads are not trapped, therefore
+	// not recorded, time must be obtained through system calls (see
+	// syscall.Nanotime()).
+	Deterministic bool
+
+	// Replicas represents additional redundant execution contexts for
+	// modular redundancy, together with the primary context they must
+	// amount to at least three contexts (e.g. two Replicas for Triple
+	// Modular Redundancy).
+	//
+	// Each replica must have its own Memory, mapped by its own PageTable
+	// at the same virtual addresses of the primary one, as otherwise all
+	// contexts would operate on the same memory contents. Replicas are
+	// therefore meant to be created with CloneMemory() and
+	// InitPageTable(), Run() raises an error if a replica shares the
+	// primary context Memory.
+	//
+	// When set Run() will Schedule() the primary context and all Replicas
+	// and vote on their resulting state (see Equal), execution continues
+	// with the majority state while outvoted contexts, including the
+	// primary one, are restored from it. Contexts raising an unhandled
+	// exception count as outvoted. Run() raises an error when no majority
+	// is reached.
+	Replicas []*ExecCtx
+
+	// Outvoted, if not nil, is invoked with each context outvoted at
+	// Replicas voting, before its state is restored, to report the fault.
+	// An error stops the primary context Run().
+	Outvoted func(primary *ExecCtx, outvoted *ExecCtx) error
+
+	// Injector, if not nil, injects faults before each scheduling cycle
+	// of Run(), it is meant exclusively for the validation of lockstep
+	// configurations and handlers (see fault.Campaign).
+	Injector *fault.Injector
+
+	// Record, if not nil, receives the inputs provided by the monitor to
+	// the execution context (see Input), such as system call return values
+	// and buffers, as a JSON stream for later replay. Each synchronous
+	// exception is recorded along with the execution context register
+	// state.
+	Record io.Writer
+
+	// Replay, if not nil, provides inputs previously recorded (see Record)
+	// which are applied, in place of the context Handler, at each
+	// synchronous exception. Run() returns an error if the execution
+	// context state differs from the recorded one (see Equal) and stops
+	// at the end of the stream.
+	//
+	// Interrupts are not replayed, as asynchronous, and are passed to the
+	// context Handler, the inputs it provides are therefore not recorded.
+	Replay io.Reader
+
 	// execution state
 	run bool
+	// scheduling cycles since Run()
+	cycles uint
+	// deterministic lockstep input log
+	log inputLog
+	// record and replay state
+	rec recording
+	// pristine state
+	pristine *snapshot
 	// stopped will be closed once the context has stopped running.
 	stopped chan struct{}
+	// cancel is the done channel of the context passed to RunContext()
+	cancel <-chan struct{}
+	// deadline of the context passed to RunContext()
+	deadline time.Time
+	// halt is closed by Stop() while the context is running.
+	halt chan struct{}
 	// TrustZone configuration
 	ns bool
 	// executing g stack pointer
@@ -152,6 +244,9 @@ type ExecCtx struct {
 	in []byte
 	// Write() buffer
 	out []byte
+
+	// Bridge requests
+	queue *queue
 }
 
 // String returns the string form of the execution context registers.
@@ -181,6 +276,40 @@ func (ctx *ExecCtx) Mode() (current int, saved int) {
 	return
 }
 
+// nonSecure returns whether the execution context is loaded as non-secure.
+func (ctx *ExecCtx) nonSecure() bool {
+	return ctx.ns
+}
+
+// interrupt returns whether the execution context yielded due to an
+// interrupt, rather than an exception.
+func (ctx *ExecCtx) interrupt() bool {
+	return ctx.ExceptionVector == arm.IRQ || ctx.ExceptionVector == arm.FIQ
+}
+
+// systemCall returns whether the execution context yielded due to a system
+// call (SVC).
+func (ctx *ExecCtx) systemCall() bool {
+	return ctx.ExceptionVector == arm.SUPERVISOR
+}
+
+// unhandledInterrupt has no effect as default handlers treat all exceptions
+// as system or monitor calls on ARM.
+func (ctx *ExecCtx) unhandledInterrupt() error {
+	return nil
+}
+
+// rewind returns to the interrupted instruction when handling interrupts
+// (Table 11-3, ARM® Cortex™ -A Series Programmer’s Guide).
+func (ctx *ExecCtx) rewind() {
+	ctx.R15 -= 4
+}
+
+// emulate has no effect as direct counter reads are not trapped on ARM.
+func (ctx *ExecCtx) emulate(_ *ExecCtx) bool {
+	return false
+}
+
 // Schedule runs the execution context until an exception is caught.
 //
 // Unlike Run() the function does not invoke the context Handler(), there
@@ -197,9 +326,19 @@ func (ctx *ExecCtx) Schedule() (err error) {
 		ctx.MMU()
 	}
 
+	// switch address space as needed
+	if ctx.PageTable != nil {
+		ctx.PageTable.activate()
+	}
+
 	// execute context
 	Exec(ctx)
 
+	// restore Trusted OS address space
+	if ctx.PageTable != nil {
+		ctx.PageTable.deactivate()
+	}
+
 	// restore default handlers
 	imx6ul.ARM.SetVectorTable(systemVectorTable)
 
@@ -222,13 +361,14 @@ func (ctx *ExecCtx) Schedule() (err error) {
 // The function invokes the context Handler() and returns when an unhandled
 // exception, or any other error, is raised.
 func (ctx *ExecCtx) Run() (err error) {
-	ctx.run = true
-	ctx.stopped = make(chan struct{})
-	defer close(ctx.stopped)
+	stopped := ctx.begin()
+	defer close(stopped)
+
+	ctx.cycles = 0
 
 	ap := arm.TTE_AP_001
 
-	if !ctx.ns {
+	if !ctx.ns && ctx.PageTable == nil {
 		ap = arm.TTE_AP_011
 	}
 
@@ -238,11 +378,27 @@ func (ctx *ExecCtx) Run() (err error) {
 		ap, ctx.Domain,
 	)
 
-	for ctx.run {
-		if err = ctx.Schedule(); err != nil {
+	for ctx.run && !ctx.canceled() {
+		if ctx.Injector != nil {
+			if err = ctx.inject(); err != nil {
+				break
+			}
+		}
+
+		err = ctx.Schedule()
+
+		// a failed primary is outvoted rather than stopped
+		if len(ctx.Replicas) > 0 {
+			err = ctx.vote(err)
+		}
+
+		if err != nil {
 			break
 		}
 
+		ctx.cycles += 1
+		ctx.mark()
+
 		if ctx.Shadow != nil {
 			err = ctx.Shadow.lockstep(ctx)
 			ctx.MMU()
@@ -252,17 +408,25 @@ func (ctx *ExecCtx) Run() (err error) {
 			}
 		}
 
-		if ctx.Handler != nil {
+		// interrupts are handled, rather than replayed, as asynchronous
+		if ctx.Replay != nil && !ctx.interrupt() {
+			if err = ctx.replay(); err != nil {
+				break
+			}
+		} else if ctx.Handler != nil {
 			if err = ctx.Handler(ctx); err != nil {
 				break
 			}
 		}
 
-		// Return to next instruction when handling interrupts
-		// (Table 11-3, ARM® Cortex™ -A Series Programmer’s Guide).
-		switch ctx.ExceptionVector {
-		case arm.IRQ, arm.FIQ:
-			ctx.R15 -= 4
+		if ctx.Record != nil {
+			if err = ctx.save(); err != nil {
+				break
+			}
+		}
+
+		if ctx.interrupt() {
+			ctx.rewind()
 		}
 
 		runtime.Gosched()
@@ -271,19 +435,6 @@ func (ctx *ExecCtx) Run() (err error) {
 	return
 }
 
-// Stop stops the execution context.
-func (ctx *ExecCtx) Stop() {
-	mux.Lock()
-	defer mux.Unlock()
-
-	ctx.run = false
-}
-
-// Done returns a channel which will be closed once execution context has stopped.
-func (ctx *ExecCtx) Done() chan struct{} {
-	return ctx.stopped
-}
-
 // Load returns an execution context initialized for the argument entry point
 // and memory region, the secure flag controls whether the context belongs to a
 // secure partition (e.g. TrustZone Secure World) or a non-secure one (e.g.
@@ -341,28 +492,112 @@ func Load(entry uint, mem *dma.Region, secure bool) (ctx *ExecCtx, err error) {
 	return
 }
 
+// setRegisters copies the register state of src to the execution context.
+func (ctx *ExecCtx) setRegisters(src *ExecCtx) {
+	ctx.R0, ctx.R1, ctx.R2, ctx.R3 = src.R0, src.R1, src.R2, src.R3
+	ctx.R4, ctx.R5, ctx.R6, ctx.R7 = src.R4, src.R5, src.R6, src.R7
+	ctx.R8, ctx.R9, ctx.R10, ctx.R11 = src.R8, src.R9, src.R10, src.R11
+	ctx.R12, ctx.R13, ctx.R14, ctx.R15 = src.R12, src.R13, src.R14, src.R15
+
+	ctx.CPSR = src.CPSR
+	ctx.SPSR = src.SPSR
+	ctx.ExceptionVector = src.ExceptionVector
+
+	ctx.VFP = slices.Clone(src.VFP)
+	ctx.FPSCR = src.FPSCR
+	ctx.FPEXC = src.FPEXC
+}
+
+// exception returns the execution context exception vector.
+func (ctx *ExecCtx) exception() uint64 {
+	return uint64(ctx.ExceptionVector)
+}
+
+// diff returns the register state differences between a and b.
+func diff(a, b *ExecCtx) (d []Difference) {
+	ra := []uint32{a.R0, a.R1, a.R2, a.R3, a.R4, a.R5, a.R6, a.R7, a.R8, a.R9, a.R10, a.R11, a.R12, a.R13, a.R14, a.R15}
+	rb := []uint32{b.R0, b.R1, b.R2, b.R3, b.R4, b.R5, b.R6, b.R7, b.R8, b.R9, b.R10, b.R11, b.R12, b.R13, b.R14, b.R15}
+
+	for i := range ra {
+		if ra[i] != rb[i] {
+			d = append(d, Difference{fmt.Sprintf("R%d", i), ra[i], rb[i]})
+		}
+	}
+
+	if a.CPSR != b.CPSR {
+		d = append(d, Difference{"CPSR", a.CPSR, b.CPSR})
+	}
+
+	if a.SPSR != b.SPSR {
+		d = append(d, Difference{"SPSR", a.SPSR, b.SPSR})
+	}
+
+	if a.FPSCR != b.FPSCR {
+		d = append(d, Difference{"FPSCR", a.FPSCR, b.FPSCR})
+	}
+
+	if a.FPEXC != b.FPEXC {
+		d = append(d, Difference{"FPEXC", a.FPEXC, b.FPEXC})
+	}
+
+	if len(a.VFP) != len(b.VFP) {
+		d = append(d, Difference{"VFP", a.VFP, b.VFP})
+	} else {
+		for i := range a.VFP {
+			if a.VFP[i] != b.VFP[i] {
+				d = append(d, Difference{fmt.Sprintf("D%d", i), a.VFP[i], b.VFP[i]})
+			}
+		}
+	}
+
+	if !slices.Equal(a.in, b.in) {
+		d = append(d, Difference{"in", a.in, b.in})
+	}
+
+	return
+}
+
+// state returns the execution context register state.
+func (ctx *ExecCtx) state() []uint64 {
+	s := []uint64{
+		uint64(ctx.R0), uint64(ctx.R1), uint64(ctx.R2), uint64(ctx.R3),
+		uint64(ctx.R4), uint64(ctx.R5), uint64(ctx.R6), uint64(ctx.R7),
+		uint64(ctx.R8), uint64(ctx.R9), uint64(ctx.R10), uint64(ctx.R11),
+		uint64(ctx.R12), uint64(ctx.R13), uint64(ctx.R14), uint64(ctx.R15),
+		uint64(ctx.CPSR), uint64(ctx.SPSR), uint64(ctx.FPSCR), uint64(ctx.FPEXC),
+	}
+
+	return append(s, ctx.VFP...)
+}
+
+// setState sets the execution context register state, as returned by
+// state().
+func (ctx *ExecCtx) setState(s []uint64) bool {
+	if len(s) < 20 {
+		return false
+	}
+
+	regs := []*uint32{
+		&ctx.R0, &ctx.R1, &ctx.R2, &ctx.R3, &ctx.R4, &ctx.R5, &ctx.R6, &ctx.R7,
+		&ctx.R8, &ctx.R9, &ctx.R10, &ctx.R11, &ctx.R12, &ctx.R13, &ctx.R14, &ctx.R15,
+		&ctx.CPSR, &ctx.SPSR, &ctx.FPSCR, &ctx.FPEXC,
+	}
+
+	for i, r := range regs {
+		*r = uint32(s[i])
+	}
+
+	ctx.VFP = slices.Clone(s[20:])
+
+	return true
+}
+
+// replayCounter has no effect as direct counter reads are not trapped on ARM.
+func (ctx *ExecCtx) replayCounter(_ uint64) bool {
+	return false
+}
+
 // Equal returns whether a and b holds the same register state.
 func Equal(a, b *ExecCtx) bool {
-	return (a.R0 == b.R0 &&
-		a.R1 == b.R1 &&
-		a.R2 == b.R2 &&
-		a.R3 == b.R3 &&
-		a.R4 == b.R4 &&
-		a.R5 == b.R5 &&
-		a.R6 == b.R6 &&
-		a.R7 == b.R7 &&
-		a.R8 == b.R8 &&
-		a.R9 == b.R9 &&
-		a.R10 == b.R10 &&
-		a.R11 == b.R11 &&
-		a.R12 == b.R12 &&
-		a.R13 == b.R13 &&
-		a.R14 == b.R14 &&
-		a.R15 == b.R15 &&
-		a.CPSR == b.CPSR &&
-		a.SPSR == b.SPSR &&
-		a.FPSCR == b.FPSCR &&
-		a.FPEXC == b.FPEXC &&
-		slices.Equal(a.VFP, b.VFP) &&
-		slices.Equal(a.in, b.in))
+	return len(diff(a, b)) == 0
 }
@@ -6,35 +6,365 @@
 package monitor
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/usbarmory/tamago/dma"
 )
 
+// Difference represents an execution context field which differs between the
+// primary and Shadow execution contexts.
+type Difference struct {
+	// Field is the register, buffer or memory location name
+	Field string
+	// Primary is the primary execution context value
+	Primary any
+	// Shadow is the Shadow execution context value
+	Shadow any
+}
+
+// LockstepError represents a lockstep failure, it is returned by Run() when
+// the primary and Shadow execution context state differs.
+type LockstepError struct {
+	// Cycle is the primary execution context scheduling cycle, counted
+	// since Run(), at which the failure was detected.
+	Cycle uint
+
+	// Exception is the exception vector (ARM) or trap cause (RISC-V) of
+	// the primary execution context.
+	Exception uint64
+	// ShadowException is the exception vector (ARM) or trap cause
+	// (RISC-V) of the Shadow execution context.
+	ShadowException uint64
+
+	// Differences lists each differing field
+	Differences []Difference
+
+	// Nondeterministic reports, in deterministic lockstep only (see
+	// Deterministic field), whether nondeterministic events which could
+	// not be recorded or replayed occurred since the last comparison,
+	// suggesting a divergence caused by nondeterminism rather than by a
+	// fault.
+	Nondeterministic bool
+	// Inputs lists the inputs recorded from the primary execution context
+	// and replayed into the Shadow since the last comparison.
+	Inputs []Input
+}
+
+// Error returns a single line summary of the lockstep failure.
+func (e *LockstepError) Error() string {
+	var fields []string
+
+	for _, d := range e.Differences {
+		fields = append(fields, d.Field)
+	}
+
+	cause := "fault"
+
+	if e.Nondeterministic {
+		cause = "nondeterminism"
+	}
+
+	return fmt.Sprintf("lockstep failure at cycle %d (exception:%#x shadow:%#x cause:%s), differing: %s",
+		e.Cycle, e.Exception, e.ShadowException, cause, strings.Join(fields, ","))
+}
+
+// Diff returns a multi-line rendering of the lockstep failure, suitable for
+// logging, where each differing field is listed with its primary (-) and
+// Shadow (+) values.
+func (e *LockstepError) Diff() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "lockstep failure at cycle %d\n", e.Cycle)
+
+	if e.Nondeterministic {
+		fmt.Fprintf(&b, "unrecorded nondeterminism (%d inputs replayed)\n", len(e.Inputs))
+	}
+
+	fmt.Fprintf(&b, "- exception %#x\n", e.Exception)
+	fmt.Fprintf(&b, "+ exception %#x\n", e.ShadowException)
+
+	for _, d := range e.Differences {
+		fmt.Fprintf(&b, "- %-8s %#x\n", d.Field, d.Primary)
+		fmt.Fprintf(&b, "+ %-8s %#x\n", d.Field, d.Shadow)
+	}
+
+	return b.String()
+}
+
+// MemoryCheck represents the optional comparison of primary and Shadow
+// execution context memory during soft lockstep, to detect faults which
+// corrupt memory without (yet) affecting register state.
+//
+// The Shadow execution context must have its own Memory, mapped at the same
+// virtual addresses of the primary one (see Shadow field and CloneMemory()).
+type MemoryCheck struct {
+	// Interval is the number of lockstep cycles between memory
+	// comparisons, zero disables memory comparison.
+	Interval uint
+
+	// Checks is the number of memory comparisons performed.
+	Checks uint
+	// Overhead is the cumulative time spent on memory comparisons.
+	Overhead time.Duration
+
+	// lockstep cycles
+	cycles uint
+}
+
+// compare returns the first difference between the argument memory regions
+// contents, the comparison is performed only at the configured interval.
+func (m *MemoryCheck) compare(primary *dma.Region, shadow *dma.Region) (d []Difference, err error) {
+	if m.Interval == 0 {
+		return
+	}
+
+	if m.cycles += 1; m.cycles%m.Interval != 0 {
+		return
+	}
+
+	start := time.Now()
+
+	defer func() {
+		m.Checks += 1
+		m.Overhead += time.Since(start)
+	}()
+
+	if primary == shadow || primary.Size() != shadow.Size() {
+		return nil, errors.New("lockstep memory check requires distinct Shadow memory")
+	}
+
+	a := mem(primary.Start(), int(primary.Size()))
+	b := mem(shadow.Start(), int(shadow.Size()))
+
+	if bytes.Equal(a, b) {
+		return
+	}
+
+	for off := 0; off < len(a); off++ {
+		if a[off] != b[off] {
+			return []Difference{{fmt.Sprintf("Memory[%#x]", off), a[off], b[off]}}, nil
+		}
+	}
+
+	return
+}
+
 // Clone returns a duplicate execution context suitable for lockstep operation
-// (see Shadow field), the original Handler field is not carried over in the
-// shadow copy.
+// (see Shadow field), the original Handler, Shadow, Replicas and Outvoted
+// fields are not carried over in the shadow copy.
+//
+// The duplicate shares the execution context Memory, replicas for modular
+// redundancy must be created with CloneMemory() instead (see Replicas field).
 func (ctx *ExecCtx) Clone() (shadow *ExecCtx) {
 	s := *ctx
 	s.Handler = nil
+	s.Shadow = nil
+	s.Replicas = nil
+	s.Outvoted = nil
+	s.log = inputLog{}
+	s.Record = nil
+	s.Replay = nil
+	s.rec = recording{}
 
 	return &s
 }
 
-// lockstep runs a shadow execution context for a single scheduling cycle, an
-// error is raised when the resulting state differs from the primary execution
-// context.
+// CloneMemory returns a duplicate execution context, as Clone(), with its own
+// Memory set to the argument region, where the execution context memory
+// contents are copied, to allow lockstep memory comparison (see MemoryCheck).
+//
+// The argument region must match the execution context Memory size, the
+// duplicate PageTable is not carried over and must be initialized to map the
+// argument region at the same virtual addresses (see InitPageTable()).
+func (ctx *ExecCtx) CloneMemory(m *dma.Region) (shadow *ExecCtx, err error) {
+	if m == nil || m == ctx.Memory || m.Size() != ctx.Memory.Size() {
+		return nil, errors.New("invalid Shadow memory")
+	}
+
+	shadow = ctx.Clone()
+	shadow.Memory = m
+	shadow.PageTable = nil
+
+	copy(mem(m.Start(), int(m.Size())), mem(ctx.Memory.Start(), int(ctx.Memory.Size())))
+
+	return
+}
+
+// lockstep runs a shadow execution context for a single scheduling cycle, a
+// LockstepError is raised when the resulting state differs from the primary
+// execution context.
+//
+// In deterministic lockstep (see Deterministic field) the comparison takes
+// place only at synchronous exceptions, with counter reads replayed from the
+// primary execution context.
 func (shadow *ExecCtx) lockstep(primary *ExecCtx) (err error) {
-	if err = shadow.Schedule(); err != nil {
+	if primary.Deterministic && primary.interrupt() {
+		primary.record(InputInterrupt, []uint64{primary.exception()}, 0, nil)
 		return
 	}
 
+	for {
+		if err = shadow.Schedule(); err != nil {
+			return
+		}
+
+		if !primary.Deterministic || !shadow.interrupt() {
+			break
+		}
+
+		primary.log.unrecorded += 1
+
+		if primary.Handler != nil {
+			if err = primary.Handler(shadow); err != nil {
+				return
+			}
+		}
+
+		shadow.rewind()
+	}
+
+	if primary.Deterministic {
+		shadow.emulate(primary)
+
+		if primary.log.pending(InputCounter) {
+			primary.log.unrecorded += 1
+		}
+	}
+
 	if shadow.Handler != nil {
 		if err = shadow.Handler(primary); err != nil {
 			return
 		}
 	}
 
-	if !Equal(primary, shadow) {
-		return errors.New("lockstep failure")
+	d := diff(primary, shadow)
+
+	if len(d) == 0 {
+		if d, err = primary.MemoryCheck.compare(primary.Memory, shadow.Memory); err != nil {
+			return
+		}
+	}
+
+	if len(d) == 0 {
+		primary.log.reset()
+		return
+	}
+
+	return &LockstepError{
+		Cycle:            primary.cycles,
+		Exception:        primary.exception(),
+		ShadowException:  shadow.exception(),
+		Differences:      d,
+		Nondeterministic: primary.log.unrecorded > 0,
+		Inputs:           primary.log.inputs,
+	}
+}
+
+// redundant returns all redundant execution contexts (see Shadow and Replicas
+// fields).
+func (ctx *ExecCtx) redundant() (r []*ExecCtx) {
+	if ctx.Shadow != nil {
+		r = append(r, ctx.Shadow)
+	}
+
+	return append(r, ctx.Replicas...)
+}
+
+// restore replaces the execution context state with the src one, memory
+// contents are also copied when the two contexts have distinct Memory.
+func (ctx *ExecCtx) restore(src *ExecCtx) {
+	ctx.setRegisters(src)
+	ctx.in = slices.Clone(src.in)
+
+	if ctx.Memory == src.Memory || ctx.Memory.Size() != src.Memory.Size() {
+		return
+	}
+
+	copy(mem(ctx.Memory.Start(), int(ctx.Memory.Size())), mem(src.Memory.Start(), int(src.Memory.Size())))
+}
+
+// vote runs all replicas for a single scheduling cycle and performs majority
+// voting on the resulting state of the primary and replica contexts, outvoted
+// contexts are restored from the majority state.
+//
+// Contexts whose scheduling failed (e.g. on an unhandled exception), including
+// the primary one as reported by the argument error, are counted as
+// dissenting votes and restored as well.
+//
+// Replicas do not invoke their Handler, therefore system or monitor call side
+// effects are only applied once by the primary context Handler, with return
+// values propagated to all replicas (see Ret()).
+func (primary *ExecCtx) vote(primaryErr error) (err error) {
+	ctxs := append([]*ExecCtx{primary}, primary.Replicas...)
+
+	if len(ctxs) < 3 {
+		return errors.New("modular redundancy requires at least three contexts")
+	}
+
+	for _, replica := range primary.Replicas {
+		if replica.Memory == primary.Memory {
+			return errors.New("modular redundancy requires distinct replica memory")
+		}
+	}
+
+	failed := map[*ExecCtx]error{}
+
+	if primaryErr != nil {
+		failed[primary] = primaryErr
+	}
+
+	for _, replica := range primary.Replicas {
+		if err := replica.Schedule(); err != nil {
+			failed[replica] = err
+		}
+	}
+
+	if primary.MMU != nil {
+		primary.MMU()
+	}
+
+	var majority *ExecCtx
+
+	for _, a := range ctxs {
+		if failed[a] != nil {
+			continue
+		}
+
+		votes := 0
+
+		for _, b := range ctxs {
+			if failed[b] == nil && Equal(a, b) {
+				votes += 1
+			}
+		}
+
+		if votes > len(ctxs)/2 {
+			majority = a
+			break
+		}
+	}
+
+	if majority == nil {
+		return errors.Join(errors.New("modular redundancy failure, no majority"), primaryErr)
+	}
+
+	for _, ctx := range ctxs {
+		if failed[ctx] == nil && Equal(ctx, majority) {
+			continue
+		}
+
+		if primary.Outvoted != nil {
+			if err = primary.Outvoted(primary, ctx); err != nil {
+				return
+			}
+		}
+
+		ctx.restore(majority)
 	}
 
 	return
@@ -4,7 +4,7 @@
 // that can be found in the LICENSE file.
 
 // Package monitor provides supervisor support for TamaGo unikernels to allow
-// scheduling of isolated supervisor executables.
+// scheduling of isolated supervisor or user mode executables.
 //
 // This package is only meant to be used with `GOOS=tamago GOARCH=riscv64` as
 // supported by the TamaGo framework for bare metal Go, see
@@ -12,16 +12,21 @@
 package monitor
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"net/rpc"
 	"runtime"
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/usbarmory/tamago/dma"
 	"github.com/usbarmory/tamago/riscv64"
 	"github.com/usbarmory/tamago/soc/sifive/fu540"
+
+	"github.com/usbarmory/GoTEE/fault"
 )
 
 // RISC-V privilege levels
@@ -42,9 +47,9 @@ func init() {
 	}
 }
 
-// Exec allows execution of an executable in supervisor mode. The execution is
-// isolated from the invoking Go runtime, yielding back to it is supported
-// through exceptions (e.g. syscalls through ECALL).
+// Exec allows execution of an executable in supervisor or user mode. The
+// execution is isolated from the invoking Go runtime, yielding back to it is
+// supported through exceptions (e.g. syscalls through ECALL).
 //
 // The execution context pointer allows task initialization and it is updated
 // with the program state at return, it can therefore be passed again to resume
@@ -91,6 +96,8 @@ type ExecCtx struct {
 	MEPC uint64
 	// Machine Cause
 	MCAUSE uint64
+	// Machine Trap Value
+	MTVAL uint64
 
 	// floating-point registers
 	F [32]uint64 // F0-F31
@@ -103,14 +110,47 @@ type ExecCtx struct {
 	//
 	// The integer argument is passed as first available PMP entry, the PMP
 	// function must not modify previous entries as they are used at
-	// scheduling to grant execution context access to its own memory.
+	// scheduling to grant execution context access to its own memory and
+	// to configure PMPConfig entries.
 	PMP func(ctx *ExecCtx, pmpEntry int) error
 
+	// PMPConfig, if not nil, adds application physical memory protection
+	// entries through the PMP allocator, it is invoked before PMP.
+	//
+	// The argument PMP allocator (see DefaultPMP) is passed with entries
+	// already allocated at scheduling to grant execution context access to
+	// its own memory, the PMPConfig function must only add entries.
+	PMPConfig func(ctx *ExecCtx, pmp *PMP) error
+
+	// Delegation represents the traps delegated to the execution context
+	// Supervisor mode handlers, rather than the monitor, it is applied at
+	// each Schedule() (see TrustedDelegation, KernelDelegation).
+	Delegation Delegation
+
+	// Interrupts is the bitmask of machine interrupt codes enabled (mie) at
+	// each Schedule(), these are passed to the context Handler() which
+	// must clear their source (default handlers return an error).
+	Interrupts uint64
+
+	// Hart is the hart identifier of the execution context, within its
+	// hart group (see Harts.Add()).
+	Hart uint64
+	// Harts, if not nil, represents the hart group of a multi-hart guest.
+	Harts *Harts
+
 	// MMU, if not nil, is called before each execution context Schedule()
 	// or Write() to allow virtual addressing re-configuration as needed
 	// (see arm.ConfigureMMU).
 	MMU func()
 
+	// PageTable, if not nil, is activated through the satp register while
+	// the execution context is scheduled, giving it its own virtual address
+	// space (see InitPageTable()).
+	//
+	// A Shadow execution context requires its own PageTable, mapping its
+	// own Memory.
+	PageTable *PageTable
+
 	// Handler, if not nil, handles context switch calls
 	Handler func(ctx *ExecCtx) error
 
@@ -123,15 +163,107 @@ type ExecCtx struct {
 	//
 	// When set Run() will Schedule() the primary and Shadow context for
 	// opportunistic comparison, in case of a mismatch (see Equal) the
-	// primary context Run() raises an error.
+	// primary context Run() raises a LockstepError.
 	Shadow *ExecCtx
 
+	// MemoryCheck configures the optional comparison of primary and
+	// Shadow memory at lockstep.
+	MemoryCheck MemoryCheck
+
+	// Deterministic enables deterministic soft lockstep (see Shadow),
+	// where nondeterministic inputs provided by the monitor are recorded
+	// from the primary execution context and replayed into the Shadow.
+	//
+	// Interrupts caught by the primary context are recorded and the
+	// Shadow is compared only at its next synchronous exception, while
+	// interrupts caught by the Shadow are passed to the primary context
+	// Handler, with the Shadow as argument, and counted as unrecorded
+	// nondeterminism (see LockstepError).
+	//
+	// Time and performance counter reads (time, cycle, instret) are
+	// trapped (mcounteren) and emulated by the monitor, the primary context
+	// reads are recorded and replayed into the Shadow and Replicas. The
+	// illegal instruction exception must therefore not be delegated (see
+	// Delegation), Schedule() returns an error otherwise.
+	Deterministic bool
+
+	// Replicas represents additional redundant execution contexts for
+	// modular redundancy, together with the primary context they must
+	// amount to at least three contexts (e.g. two Replicas for Triple
+	// Modular Redundancy).
+	//
+	// Each replica must have its own Memory, mapped by its own PageTable
+	// at the same virtual addresses of the primary one, as otherwise all
+	// contexts would operate on the same memory contents. Replicas are
+	// therefore meant to be created with CloneMemory() and
+	// InitPageTable(), Run() raises an error if a replica shares the
+	// primary context Memory.
+	//
+	// When set Run() will Schedule() the primary context and all Replicas
+	// and vote on their resulting state (see Equal), execution continues
+	// with the majority state while outvoted contexts, including the
+	// primary one, are restored from it. Contexts raising an unhandled
+	// exception count as outvoted. Run() raises an error when no majority
+	// is reached.
+	Replicas []*ExecCtx
+
+	// Outvoted, if not nil, is invoked with each context outvoted at
+	// Replicas voting, before its state is restored, to report the fault.
+	// An error stops the primary context Run().
+	Outvoted func(primary *ExecCtx, outvoted *ExecCtx) error
+
+	// Injector, if not nil, injects faults before each scheduling cycle
+	// of Run(), it is meant exclusively for the validation of lockstep
+	// configurations and handlers (see fault.Campaign).
+	Injector *fault.Injector
+
+	// Record, if not nil, receives the inputs provided by the monitor to
+	// the execution context (see Input), such as system call return values
+	// and buffers, as a JSON stream for later replay. Each synchronous
+	// exception is recorded along with the execution context register
+	// state.
+	Record io.Writer
+
+	// Replay, if not nil, provides inputs previously recorded (see Record)
+	// which are applied, in place of the context Handler, at each
+	// synchronous exception. Run() returns an error if the execution
+	// context state differs from the recorded one (see Equal) and stops
+	// at the end of the stream.
+	//
+	// Interrupts are not replayed, as asynchronous, and are passed to the
+	// context Handler, the inputs it provides are therefore not recorded.
+	Replay io.Reader
+
 	// execution state
 	run bool
+	// scheduling cycles since Run()
+	cycles uint
+	// deterministic lockstep input log
+	log inputLog
+	// record and replay state
+	rec recording
+	// pristine state
+	pristine *snapshot
 	// stopped will be closed once the context has stopped running.
 	stopped chan struct{}
+	// cancel is the done channel of the context passed to RunContext()
+	cancel <-chan struct{}
+	// deadline of the context passed to RunContext()
+	deadline time.Time
+	// halt is closed by Stop() while the context is running.
+	halt chan struct{}
 	// trusted applet flag
 	secure bool
+	// User privilege level flag, Supervisor when false
+	user bool
+	// hart state
+	hartState int
+	// hart start requests
+	start chan struct{}
+	// pending inter-processor requests
+	requests int
+	// hart group timer state
+	timer hartTimer
 	// executing g stack pointer
 	g_sp uint64
 
@@ -139,6 +271,9 @@ type ExecCtx struct {
 	in []byte
 	// Write() buffer
 	out []byte
+
+	// Bridge requests
+	queue *queue
 }
 
 // String returns the string form of the execution context registers.
@@ -165,6 +300,16 @@ func (ctx *ExecCtx) Secure() bool {
 	return ctx.secure
 }
 
+// Privilege returns the execution context privilege level (User or
+// Supervisor).
+func (ctx *ExecCtx) Privilege() int {
+	if ctx.user {
+		return User
+	}
+
+	return Supervisor
+}
+
 // Cause returns the trap event.
 func (ctx *ExecCtx) Cause() (code uint64, irq bool) {
 	code = (ctx.MCAUSE &^ (1 << 63))
@@ -172,45 +317,124 @@ func (ctx *ExecCtx) Cause() (code uint64, irq bool) {
 	return
 }
 
+// nonSecure returns whether the execution context is loaded as non-secure.
+func (ctx *ExecCtx) nonSecure() bool {
+	return !ctx.secure
+}
+
+// interrupt returns whether the execution context yielded due to an
+// interrupt, rather than an exception.
+func (ctx *ExecCtx) interrupt() bool {
+	_, irq := ctx.Cause()
+	return irq
+}
+
+// systemCall returns whether the execution context yielded due to a system
+// call (ECALL).
+func (ctx *ExecCtx) systemCall() bool {
+	code, irq := ctx.Cause()
+	return !irq && (code == riscv64.EnvironmentCallFromU || code == riscv64.EnvironmentCallFromS)
+}
+
+// unhandledInterrupt returns an error if the execution context yielded due to
+// an interrupt, it is used by default handlers which cannot clear its source.
+func (ctx *ExecCtx) unhandledInterrupt() (err error) {
+	if code, irq := ctx.Cause(); irq {
+		return fmt.Errorf("unhandled interrupt %d", code)
+	}
+
+	return
+}
+
+// rewind returns to the interrupted instruction when handling interrupts.
+func (ctx *ExecCtx) rewind() {
+	ctx.PC -= 4
+}
+
 // Schedule runs the execution context until an exception is caught.
 //
 // Unlike Run() the function does not invoke the context Handler(), there
 // exceptions and system or monitor calls are not handled.
 func (ctx *ExecCtx) Schedule() (err error) {
-	var pmpEntry int
-
 	mux.Lock()
 	defer mux.Unlock()
 
+	// counter reads must trap to the monitor for deterministic lockstep
+	if ctx.Deterministic && ctx.Delegation.Exceptions&(1<<riscv64.IllegalInstruction) != 0 {
+		return errors.New("illegal instruction delegation prevents deterministic lockstep")
+	}
+
 	// set monitor handlers
 	fu540.RV64.SetExceptionHandler(monitor)
 
-	// grant execution context access to its own memory
-	if pmpEntry, err = ctx.pmp(); err != nil {
+	// set up physical memory protection
+	if err = ctx.pmp(); err != nil {
 		return
 	}
 
-	if ctx.PMP != nil {
-		// set up application physical memory protection
-		if err = ctx.PMP(ctx, pmpEntry); err != nil {
-			return
-		}
+	// set up trap delegation
+	ctx.Delegation.apply()
+
+	// trap counter reads for deterministic lockstep
+	if ctx.Deterministic {
+		mcounteren := read_mcounteren()
+		write_mcounteren(0)
+		defer write_mcounteren(mcounteren)
+	}
+
+	// enable machine interrupts
+	if ctx.Interrupts != 0 {
+		set_mie(ctx.Interrupts)
 	}
 
+	// preempt at RunContext() deadline or hart group quantum
+	defer ctx.armPreemption()()
+
 	// reconfigure MMU as needed
 	if ctx.MMU != nil {
 		ctx.MMU()
 	}
 
+	// serve pending inter-processor requests
+	ctx.serveRequests()
+
+	// switch address space as needed
+	if ctx.PageTable != nil {
+		ctx.PageTable.activate()
+	}
+
 	// execute context
 	Exec(ctx)
 
+	// restore previous address space
+	if ctx.PageTable != nil {
+		ctx.PageTable.deactivate()
+	}
+
+	// save pending software interrupt
+	ctx.saveRequests()
+
 	// restore default handlers
 	fu540.RV64.SetExceptionHandler(riscv64.DefaultExceptionHandler)
 
 	code, irq := ctx.Cause()
 
-	if code != riscv64.EnvironmentCallFromS || irq {
+	if irq {
+		return
+	}
+
+	ecall := uint64(riscv64.EnvironmentCallFromS)
+
+	if ctx.user {
+		ecall = riscv64.EnvironmentCallFromU
+	}
+
+	if code != ecall {
+		// counter reads are emulated in deterministic lockstep
+		if _, _, ok := ctx.counterRead(); ctx.Deterministic && ok {
+			return
+		}
+
 		return fmt.Errorf("%x", code)
 	}
 
@@ -223,60 +447,133 @@ func (ctx *ExecCtx) Schedule() (err error) {
 //
 // The function invokes the context Handler() and returns when an unhandled
 // exception, or any other error, is raised.
+//
+// Interrupts which are not delegated (see Delegation) are also passed to the
+// context Handler(), which must clear their source, before execution resumes
+// at the interrupted instruction.
 func (ctx *ExecCtx) Run() (err error) {
-	ctx.run = true
-	ctx.stopped = make(chan struct{})
-	defer close(ctx.stopped)
+	stopped := ctx.begin()
+	defer close(stopped)
 
-	for ctx.run {
-		if err = ctx.Schedule(); err != nil {
+	ctx.cycles = 0
+
+	for ctx.run && !ctx.canceled() {
+		if err = ctx.step(); err != nil {
 			break
 		}
 
-		if ctx.Shadow != nil {
-			err = ctx.Shadow.lockstep(ctx)
-			ctx.MMU()
+		runtime.Gosched()
+	}
 
-			if err != nil {
-				break
-			}
-		}
+	return
+}
+
+// step runs a single Run() scheduling cycle.
+func (ctx *ExecCtx) step() (err error) {
+	defer ctx.switchHart()()
 
-		if ctx.Handler != nil {
-			if err = ctx.Handler(ctx); err != nil {
-				break
-			}
+	if ctx.Injector != nil {
+		if err = ctx.inject(); err != nil {
+			return
 		}
+	}
 
-		runtime.Gosched()
+	err = ctx.Schedule()
+
+	// a failed primary is outvoted rather than stopped
+	if len(ctx.Replicas) > 0 {
+		err = ctx.vote(err)
 	}
 
-	return
-}
+	if err != nil {
+		return
+	}
 
-// Stop stops the execution context.
-func (ctx *ExecCtx) Stop() {
-	mux.Lock()
-	defer mux.Unlock()
+	// preempted at RunContext() deadline or hart group quantum
+	if ctx.interrupt() && (ctx.canceled() || ctx.preempted()) {
+		ctx.rewind()
+		return
+	}
 
-	ctx.run = false
-}
+	ctx.cycles += 1
+	ctx.mark()
+
+	// emulate trapped counter reads
+	emulated := ctx.Deterministic && ctx.Replay == nil && ctx.emulate(nil)
 
-// Done returns a channel which will be closed once execution context has stopped.
-func (ctx *ExecCtx) Done() chan struct{} {
-	return ctx.stopped
+	if emulated {
+		ctx.replicate()
+	}
+
+	if ctx.Shadow != nil {
+		err = ctx.Shadow.lockstep(ctx)
+		ctx.MMU()
+
+		if err != nil {
+			return
+		}
+	}
+
+	// interrupts are handled, rather than replayed, as asynchronous
+	if ctx.Replay != nil && !ctx.interrupt() {
+		if err = ctx.replay(); err != nil {
+			return
+		}
+	} else if ctx.Handler != nil && !emulated {
+		if err = ctx.Handler(ctx); err != nil {
+			return
+		}
+	}
+
+	if ctx.Record != nil {
+		if err = ctx.save(); err != nil {
+			return
+		}
+	}
+
+	// Return to interrupted instruction when handling interrupts.
+	if ctx.interrupt() {
+		ctx.rewind()
+	}
+
+	return
 }
 
 // Load returns an execution context initialized for the argument entry point
-// and memory region.
+// and memory region, to be executed in Supervisor mode (see LoadMode()).
 //
 // Any additional peripheral restrictions are up to the caller.
 func Load(entry uint, mem *dma.Region, secure bool) (ctx *ExecCtx, err error) {
+	return LoadMode(entry, mem, Supervisor, secure)
+}
+
+// LoadMode returns an execution context initialized for the argument entry
+// point, memory region and privilege level.
+//
+// The User privilege level matches the ARM model of Secure user mode applets,
+// and is therefore reserved to trusted applets, with their system calls
+// handled by SecureHandler, while the Supervisor privilege level is meant for
+// kernels (e.g. a Normal World OS).
+//
+// Execution contexts which are not initialized by Load() or LoadMode() are
+// executed at Supervisor privilege level.
+//
+// Any additional peripheral restrictions are up to the caller.
+func LoadMode(entry uint, mem *dma.Region, mode int, secure bool) (ctx *ExecCtx, err error) {
+	if mode != User && mode != Supervisor {
+		return nil, fmt.Errorf("invalid privilege level %d", mode)
+	}
+
+	if mode == User && !secure {
+		return nil, errors.New("User privilege level is reserved to secure execution contexts")
+	}
+
 	ctx = &ExecCtx{
 		PC:     uint64(entry),
 		Memory: mem,
 		Server: rpc.NewServer(),
 		secure: secure,
+		user:   mode == User,
 	}
 
 	if secure {
@@ -285,59 +582,145 @@ func Load(entry uint, mem *dma.Region, secure bool) (ctx *ExecCtx, err error) {
 		ctx.Handler = NonSecureHandler
 	}
 
+	if mode == Supervisor && !secure {
+		ctx.Delegation = KernelDelegation
+	} else {
+		ctx.Delegation = TrustedDelegation
+	}
+
 	return
 }
 
-// pmp grants context access to its own memory.
-func (ctx *ExecCtx) pmp() (pmpEntry int, err error) {
-	if err = fu540.RV64.WritePMP(pmpEntry, uint64(ctx.Memory.Start()), false, false, false, riscv64.PMP_A_OFF, false); err != nil {
+// pmp grants context access to its own memory, as well as any additional
+// application physical memory protection.
+func (ctx *ExecCtx) pmp() (err error) {
+	DefaultPMP.Reset()
+
+	if _, err = DefaultPMP.Add(uint64(ctx.Memory.Start()), uint64(ctx.Memory.End()), true, true, true); err != nil {
 		return
 	}
-	pmpEntry += 1
 
-	if err = fu540.RV64.WritePMP(pmpEntry, uint64(ctx.Memory.End()), true, true, true, riscv64.PMP_A_TOR, false); err != nil {
+	if ctx.PMPConfig != nil {
+		if err = ctx.PMPConfig(ctx, DefaultPMP); err != nil {
+			return
+		}
+	}
+
+	if err = DefaultPMP.Write(); err != nil {
 		return
 	}
-	pmpEntry += 1
+
+	if ctx.PMP != nil {
+		return ctx.PMP(ctx, DefaultPMP.next())
+	}
 
 	return
 }
 
+// setRegisters copies the register state of src to the execution context.
+func (ctx *ExecCtx) setRegisters(src *ExecCtx) {
+	ctx.X1, ctx.X2, ctx.X3, ctx.X4 = src.X1, src.X2, src.X3, src.X4
+	ctx.X5, ctx.X6, ctx.X7, ctx.X8 = src.X5, src.X6, src.X7, src.X8
+	ctx.X9, ctx.X10, ctx.X11, ctx.X12 = src.X9, src.X10, src.X11, src.X12
+	ctx.X13, ctx.X14, ctx.X15, ctx.X16 = src.X13, src.X14, src.X15, src.X16
+	ctx.X17, ctx.X18, ctx.X19, ctx.X20 = src.X17, src.X18, src.X19, src.X20
+	ctx.X21, ctx.X22, ctx.X23, ctx.X24 = src.X21, src.X22, src.X23, src.X24
+	ctx.X25, ctx.X26, ctx.X27, ctx.X28 = src.X25, src.X26, src.X27, src.X28
+	ctx.X29, ctx.X30, ctx.X31 = src.X29, src.X30, src.X31
+
+	ctx.PC = src.PC
+	ctx.MEPC = src.MEPC
+	ctx.MCAUSE = src.MCAUSE
+	ctx.MTVAL = src.MTVAL
+	ctx.F = src.F
+}
+
+// exception returns the execution context trap cause.
+func (ctx *ExecCtx) exception() uint64 {
+	return ctx.MCAUSE
+}
+
+// diff returns the register state differences between a and b.
+func diff(a, b *ExecCtx) (d []Difference) {
+	xa := []uint64{
+		a.X1, a.X2, a.X3, a.X4, a.X5, a.X6, a.X7, a.X8, a.X9, a.X10,
+		a.X11, a.X12, a.X13, a.X14, a.X15, a.X16, a.X17, a.X18, a.X19, a.X20,
+		a.X21, a.X22, a.X23, a.X24, a.X25, a.X26, a.X27, a.X28, a.X29, a.X30,
+		a.X31,
+	}
+
+	xb := []uint64{
+		b.X1, b.X2, b.X3, b.X4, b.X5, b.X6, b.X7, b.X8, b.X9, b.X10,
+		b.X11, b.X12, b.X13, b.X14, b.X15, b.X16, b.X17, b.X18, b.X19, b.X20,
+		b.X21, b.X22, b.X23, b.X24, b.X25, b.X26, b.X27, b.X28, b.X29, b.X30,
+		b.X31,
+	}
+
+	for i := range xa {
+		if xa[i] != xb[i] {
+			d = append(d, Difference{fmt.Sprintf("X%d", i+1), xa[i], xb[i]})
+		}
+	}
+
+	if a.PC != b.PC {
+		d = append(d, Difference{"PC", a.PC, b.PC})
+	}
+
+	if a.MCAUSE != b.MCAUSE {
+		d = append(d, Difference{"MCAUSE", a.MCAUSE, b.MCAUSE})
+	}
+
+	for i := range a.F {
+		if a.F[i] != b.F[i] {
+			d = append(d, Difference{fmt.Sprintf("F%d", i), a.F[i], b.F[i]})
+		}
+	}
+
+	if !slices.Equal(a.in, b.in) {
+		d = append(d, Difference{"in", a.in, b.in})
+	}
+
+	return
+}
+
+// state returns the execution context register state.
+func (ctx *ExecCtx) state() []uint64 {
+	s := []uint64{
+		ctx.X1, ctx.X2, ctx.X3, ctx.X4, ctx.X5, ctx.X6, ctx.X7, ctx.X8,
+		ctx.X9, ctx.X10, ctx.X11, ctx.X12, ctx.X13, ctx.X14, ctx.X15, ctx.X16,
+		ctx.X17, ctx.X18, ctx.X19, ctx.X20, ctx.X21, ctx.X22, ctx.X23, ctx.X24,
+		ctx.X25, ctx.X26, ctx.X27, ctx.X28, ctx.X29, ctx.X30, ctx.X31,
+		ctx.PC, ctx.MCAUSE,
+	}
+
+	return append(s, ctx.F[:]...)
+}
+
+// setState sets the execution context register state, as returned by
+// state().
+func (ctx *ExecCtx) setState(s []uint64) bool {
+	if len(s) != 33+len(ctx.F) {
+		return false
+	}
+
+	regs := []*uint64{
+		&ctx.X1, &ctx.X2, &ctx.X3, &ctx.X4, &ctx.X5, &ctx.X6, &ctx.X7, &ctx.X8,
+		&ctx.X9, &ctx.X10, &ctx.X11, &ctx.X12, &ctx.X13, &ctx.X14, &ctx.X15, &ctx.X16,
+		&ctx.X17, &ctx.X18, &ctx.X19, &ctx.X20, &ctx.X21, &ctx.X22, &ctx.X23, &ctx.X24,
+		&ctx.X25, &ctx.X26, &ctx.X27, &ctx.X28, &ctx.X29, &ctx.X30, &ctx.X31,
+		&ctx.PC, &ctx.MCAUSE,
+	}
+
+	for i, r := range regs {
+		*r = s[i]
+	}
+
+	copy(ctx.F[:], s[len(regs):])
+
+	return true
+}
+
 // Equal returns whether a and b holds the same register state.
 func Equal(a, b *ExecCtx) bool {
-	return (a.X1 == b.X1 &&
-		a.X2 == b.X2 &&
-		a.X3 == b.X3 &&
-		a.X4 == b.X4 &&
-		a.X5 == b.X5 &&
-		a.X6 == b.X6 &&
-		a.X7 == b.X7 &&
-		a.X8 == b.X8 &&
-		a.X9 == b.X9 &&
-		a.X10 == b.X10 &&
-		a.X11 == b.X11 &&
-		a.X12 == b.X12 &&
-		a.X13 == b.X13 &&
-		a.X14 == b.X14 &&
-		a.X15 == b.X15 &&
-		a.X16 == b.X16 &&
-		a.X17 == b.X17 &&
-		a.X18 == b.X18 &&
-		a.X19 == b.X19 &&
-		a.X20 == b.X20 &&
-		a.X21 == b.X21 &&
-		a.X22 == b.X22 &&
-		a.X23 == b.X23 &&
-		a.X24 == b.X24 &&
-		a.X25 == b.X25 &&
-		a.X26 == b.X26 &&
-		a.X27 == b.X27 &&
-		a.X28 == b.X28 &&
-		a.X29 == b.X29 &&
-		a.X30 == b.X30 &&
-		a.X31 == b.X31 &&
-		a.PC == b.PC &&
-		a.MCAUSE == b.MCAUSE &&
-		a.F == b.F &&
-		slices.Equal(a.in, b.in))
+	return len(diff(a, b)) == 0
 }
@@ -9,8 +9,8 @@
 #include "go_asm_riscv64.h"
 
 // GoTEE exception handling relies on one exit point (Exec) and one return
-// point (monitor), both are used for execution of a Supervisor mode execution
-// context.
+// point (monitor), both are used for execution of a Supervisor or User mode
+// execution context.
 //
 // An execution context (ExecCtx) structure is used to hold initial register
 // state at execution as well as store the updated state on re-entry.
@@ -19,7 +19,7 @@
 // in a similar manner to Go own use of TLS (https://golang.org/src/runtime/tls_arm.s).
 //
 // The handler must save and restore the following registers between Machine <>
-// Supervisor mode switches:
+// Supervisor/User mode switches:
 //
 //  • x1-x31 general purpose registers:
 //
@@ -75,9 +75,17 @@ TEXT ·Exec(SB),$0-8
 	// save context pointer
 	CSRW(t0, mscratch)
 
-	// switch to supervisor mode
-	MOV	$const_Supervisor << 11, T1
+	// clear previous privilege mode (MPP)
+	MOV	$(const_Machine << 11), T1
+	CSRC(t1, mstatus)
+
+	// switch to execution context privilege mode, Supervisor unless User
+	// is selected
+	MOVBU	ExecCtx_user(T0), T1
+	BNEZ	T1, user
+	MOV	$(const_Supervisor << 11), T1
 	CSRS(t1, mstatus)
+user:
 
 	// restore floating-point registers
 	MOVD	(1*8)+ExecCtx_F(T0), F0
@@ -239,6 +247,10 @@ TEXT ·monitor(SB),NOSPLIT|NOFRAME,$0
 	CSRR(mcause, t1)
 	MOV	T1, ExecCtx_MCAUSE(T0)
 
+	// save MTVAL
+	CSRR(mtval, t1)
+	MOV	T1, ExecCtx_MTVAL(T0)
+
 	// restore g registers
 	MOV	ExecCtx_g_sp(T0), SP
 	MOV	-2*8(SP), X1
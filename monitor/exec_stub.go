@@ -14,6 +14,10 @@
 // TamaGo framework for bare metal Go, see https://github.com/usbarmory/tamago.
 package monitor
 
+// SMC (ARM) is the SMC Calling Convention dispatcher used by NonSecureHandler
+// to route secure monitor calls to registered services.
+var SMC *smccc.Dispatcher
+
 // Exec allows execution of an executable in Secure user mode or NonSecure
 // system mode (ARM) or Supervisor mode (RISC-V).
 //
@@ -46,6 +50,10 @@ func (ctx *ExecCtx) Cause() (code uint64, irq bool)
 // Mode (ARM) returns the processor mode.
 func (ctx *ExecCtx) Mode() (current int, saved int)
 
+// Privilege (RISC-V) returns the execution context privilege level (User or
+// Supervisor).
+func (ctx *ExecCtx) Privilege() int
+
 // Schedule runs the execution context until an exception is caught.
 //
 // Unlike Run() the function does not invoke the context Handler(), there
@@ -60,12 +68,6 @@ func (ctx *ExecCtx) Schedule() (err error)
 // exception, or any other error, is raised.
 func (ctx *ExecCtx) Run() (err error)
 
-// Stop stops the execution context.
-func (ctx *ExecCtx) Stop()
-
-// Done returns a channel which will be closed once execution context has stopped.
-func (ctx *ExecCtx) Done() chan struct{}
-
 // Load returns an execution context initialized for the argument entry point
 // and memory region
 //
@@ -77,8 +79,54 @@ func (ctx *ExecCtx) Done() chan struct{}
 // configuration (see arm.ConfigureMMU()) or additional peripheral restrictions
 // (e.g. TrustZone).
 //
-// RISC-V: any additional peripheral restrictions are up to the caller.
+// RISC-V: the context is executed in Supervisor mode (see LoadMode()), any
+// additional peripheral restrictions are up to the caller.
 func Load(entry uint, mem *dma.Region, secure bool) (ctx *ExecCtx, err error)
 
+// LoadMode (RISC-V) returns an execution context initialized for the argument
+// entry point, memory region and privilege level (User or Supervisor).
+func LoadMode(entry uint, mem *dma.Region, mode int, secure bool) (ctx *ExecCtx, err error)
+
+// HartID (RISC-V) returns the current hart identifier (mhartid).
+func HartID() uint64
+
+// EnableInterrupt (RISC-V) enables or disables the argument interrupt code in
+// the current hart Machine Interrupt Enable register (mie).
+func EnableInterrupt(code int, enable bool)
+
+// SetPending (RISC-V) sets or clears the argument interrupt code in the
+// current hart Machine Interrupt Pending register (mip), only Supervisor
+// interrupts are writable.
+func SetPending(code int, pending bool)
+
+// Timecmp (RISC-V) returns the machine timer compare register (mtimecmp) of
+// the argument hart.
+func Timecmp(hart uint64) uint64
+
+// SetTimecmp (RISC-V) programs the machine timer compare register (mtimecmp)
+// of the argument hart.
+func SetTimecmp(hart uint64, val uint64)
+
+// MachineIDs (RISC-V) returns the current hart vendor, architecture and
+// implementation identifiers (mvendorid, marchid, mimpid).
+func MachineIDs() (vendor uint64, arch uint64, imp uint64)
+
+// HartState (RISC-V) returns the execution context hart state (see Harts).
+func (ctx *ExecCtx) HartState() int
+
+// HartStart (RISC-V) requests a stopped execution context to start at the
+// argument address.
+func (ctx *ExecCtx) HartStart(addr uint64, opaque uint64) (err error)
+
+// WaitStart (RISC-V) blocks until the execution context is requested to start.
+func (ctx *ExecCtx) WaitStart()
+
+// HartStop (RISC-V) stops a started execution context.
+func (ctx *ExecCtx) HartStop() (err error)
+
+// Signal (RISC-V) marks inter-processor requests as pending on the execution
+// context, they are served before its next Schedule().
+func (ctx *ExecCtx) Signal(req int)
+
 // Equal returns whether a and b holds the same register state.
 func Equal(a, b *ExecCtx) bool
@@ -0,0 +1,37 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/GoTEE/fault"
+)
+
+// target implements fault.Target for an execution context.
+type target struct {
+	ctx *ExecCtx
+}
+
+// Target returns the execution context state as fault injection target (see
+// fault.Target).
+func (ctx *ExecCtx) Target() fault.Target {
+	return &target{ctx: ctx}
+}
+
+// Memory returns the execution context memory.
+func (t *target) Memory() []byte {
+	return mem(t.ctx.Memory.Start(), int(t.ctx.Memory.Size()))
+}
+
+// inject evaluates the execution context Injector before a scheduling cycle.
+func (ctx *ExecCtx) inject() (err error) {
+	var shadow fault.Target
+
+	if ctx.Shadow != nil {
+		shadow = ctx.Shadow.Target()
+	}
+
+	return ctx.Injector.Step(ctx.cycles, ctx.Target(), shadow)
+}
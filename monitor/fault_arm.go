@@ -0,0 +1,51 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+// Thumb execution state bit (B1.3.3 Program Status Registers (PSRs), ARM
+// Architecture Reference Manual ARMv7-A and ARMv7-R edition).
+const psrT = 5
+
+func (t *target) registers() []*uint32 {
+	ctx := t.ctx
+
+	return []*uint32{
+		&ctx.R0, &ctx.R1, &ctx.R2, &ctx.R3, &ctx.R4, &ctx.R5, &ctx.R6, &ctx.R7,
+		&ctx.R8, &ctx.R9, &ctx.R10, &ctx.R11, &ctx.R12, &ctx.R13, &ctx.R14, &ctx.R15,
+	}
+}
+
+// Registers returns the number of general purpose registers (R0-R15).
+func (t *target) Registers() int {
+	return 16
+}
+
+// Register returns the value of the argument general purpose register.
+func (t *target) Register(n int) uint64 {
+	return uint64(*t.registers()[n])
+}
+
+// SetRegister sets the value of the argument general purpose register.
+func (t *target) SetRegister(n int, val uint64) {
+	*t.registers()[n] = uint32(val)
+}
+
+// PC returns the execution context program counter.
+func (t *target) PC() uint64 {
+	return uint64(t.ctx.R15)
+}
+
+// Skip advances the program counter past the next ARM or Thumb instruction,
+// 32-bit Thumb instructions are not detected.
+func (t *target) Skip() error {
+	if (t.ctx.SPSR>>psrT)&1 == 1 {
+		t.ctx.R15 += 2
+	} else {
+		t.ctx.R15 += 4
+	}
+
+	return nil
+}
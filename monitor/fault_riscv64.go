@@ -0,0 +1,80 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"fmt"
+)
+
+func (t *target) registers() []*uint64 {
+	ctx := t.ctx
+
+	return []*uint64{
+		nil, &ctx.X1, &ctx.X2, &ctx.X3, &ctx.X4, &ctx.X5, &ctx.X6, &ctx.X7,
+		&ctx.X8, &ctx.X9, &ctx.X10, &ctx.X11, &ctx.X12, &ctx.X13, &ctx.X14, &ctx.X15,
+		&ctx.X16, &ctx.X17, &ctx.X18, &ctx.X19, &ctx.X20, &ctx.X21, &ctx.X22, &ctx.X23,
+		&ctx.X24, &ctx.X25, &ctx.X26, &ctx.X27, &ctx.X28, &ctx.X29, &ctx.X30, &ctx.X31,
+	}
+}
+
+// Registers returns the number of general purpose registers (X0-X31).
+func (t *target) Registers() int {
+	return 32
+}
+
+// Register returns the value of the argument general purpose register, X0 is
+// hardwired to zero.
+func (t *target) Register(n int) uint64 {
+	if n == 0 {
+		return 0
+	}
+
+	return *t.registers()[n]
+}
+
+// SetRegister sets the value of the argument general purpose register, writes
+// to X0 are ignored.
+func (t *target) SetRegister(n int, val uint64) {
+	if n == 0 {
+		return
+	}
+
+	*t.registers()[n] = val
+}
+
+// PC returns the execution context program counter.
+func (t *target) PC() uint64 {
+	return t.ctx.PC
+}
+
+// Skip advances the program counter past the next instruction, compressed
+// instructions are detected from their encoding (1.5 Base Instruction-Length
+// Encoding, RISC-V Unprivileged ISA V20191213).
+//
+// The program counter is translated through the execution context PageTable,
+// when set, and must point within its Memory.
+func (t *target) Skip() (err error) {
+	ctx := t.ctx
+	pc := ctx.PC
+
+	if ctx.PageTable != nil {
+		if pc, _, err = ctx.PageTable.table.Walk(pc); err != nil {
+			return
+		}
+	}
+
+	if pc < uint64(ctx.Memory.Start()) || pc+2 > uint64(ctx.Memory.End()) {
+		return fmt.Errorf("PC %#x outside execution context memory", ctx.PC)
+	}
+
+	if mem(uint(pc), 2)[0]&0b11 != 0b11 {
+		ctx.PC += 2
+	} else {
+		ctx.PC += 4
+	}
+
+	return
+}
@@ -8,11 +8,24 @@
 #define t0 5
 #define t1 6
 
-#define satp     0x180
-#define mstatus  0x300
-#define mscratch 0x340
-#define mepc     0x341
-#define mcause   0x342
+#define satp       0x180
+#define mstatus    0x300
+#define medeleg    0x302
+#define mideleg    0x303
+#define mie        0x304
+#define mcounteren 0x306
+#define mscratch   0x340
+#define mepc       0x341
+#define mcause     0x342
+#define mtval      0x343
+#define mip        0x344
+#define mseccfg    0x747
+#define mcycle     0xb00
+#define minstret   0xb02
+#define mvendorid  0xf11
+#define marchid    0xf12
+#define mimpid     0xf13
+#define mhartid    0xf14
 
 #define CSRW(RS,CSR) WORD $(0x1073 + RS<<15 + CSR<<20)
 #define CSRR(CSR,RD) WORD $(0x2073 + RD<<7 + CSR<<20)
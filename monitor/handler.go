@@ -9,7 +9,6 @@ import (
 	"crypto/rand"
 	"errors"
 	"fmt"
-	"log"
 	"time"
 
 	"github.com/usbarmory/GoTEE/syscall"
@@ -17,7 +16,14 @@ import (
 
 // SecureHandler is the default handler for exceptions raised by a secure
 // execution context to handle supported GoTEE system calls.
+//
+// On RISC-V interrupts (see Interrupts field) are not handled, as their source
+// cannot be cleared, and are returned as error.
 func SecureHandler(ctx *ExecCtx) (err error) {
+	if err = ctx.unhandledInterrupt(); err != nil {
+		return
+	}
+
 	switch num := ctx.A0(); num {
 	case syscall.SYS_EXIT:
 		ctx.Stop()
@@ -43,6 +49,8 @@ func SecureHandler(ctx *ExecCtx) (err error) {
 		if ctx.Server != nil {
 			err = ctx.rpc()
 		}
+	case syscall.SYS_RPC_SRV_REQ, syscall.SYS_RPC_SRV_RES:
+		err = ctx.serve(num)
 	default:
 		err = fmt.Errorf("invalid syscall %d", num)
 	}
@@ -52,8 +60,16 @@ func SecureHandler(ctx *ExecCtx) (err error) {
 
 // NonSecureHandler is the default handler for exceptions raised by a
 // non-secure execution context to handle supported GoTEE secure monitor calls.
+//
+// On RISC-V interrupts (see Interrupts field) are not handled, as their source
+// cannot be cleared, and are returned as error.
+//
+// On ARM secure monitor calls are routed to the services registered on the
+// SMC Calling Convention dispatcher (see SMC).
 func NonSecureHandler(ctx *ExecCtx) (err error) {
-	// to be overridden by application
-	log.Printf("NonSecureHandler: unimplemented")
-	return
+	if err = ctx.unhandledInterrupt(); err != nil {
+		return
+	}
+
+	return ctx.monitorCall()
 }
@@ -0,0 +1,319 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Hart states
+// (9.1 Hart states, RISC-V Supervisor Binary Interface Specification v1.0.0).
+const (
+	HartStarted = iota
+	HartStopped
+	HartStartPending
+	HartStopPending
+	HartSuspended
+	HartSuspendPending
+	HartResumePending
+)
+
+// Inter-processor requests (see Signal())
+const (
+	// RequestIPI raises a Supervisor software interrupt.
+	RequestIPI = 1 << iota
+	// RequestFence flushes address translation and instruction caches.
+	RequestFence
+)
+
+// defined in hart_riscv64.s
+func fence_i()
+func sfence_vma_all()
+func read_mvendorid() uint64
+func read_marchid() uint64
+func read_mimpid() uint64
+
+// FenceI synchronizes the instruction and data streams of the current hart.
+func FenceI() {
+	fence_i()
+}
+
+// SFenceVMA flushes all address translation caches of the current hart.
+func SFenceVMA() {
+	sfence_vma_all()
+}
+
+// MachineIDs returns the current hart vendor, architecture and implementation
+// identifiers (mvendorid, marchid, mimpid).
+func MachineIDs() (vendor uint64, arch uint64, imp uint64) {
+	return read_mvendorid(), read_marchid(), read_mimpid()
+}
+
+// DefaultQuantum is the default hart group preemption interval.
+const DefaultQuantum = 10 * time.Millisecond
+
+// Harts represents the execution contexts of an emulated multi-hart guest,
+// each execution context representing a guest hart.
+//
+// All execution contexts run on the single hart of the Trusted OS, which
+// schedules them in turn, therefore inter-processor requests are not signaled
+// through the CLINT (MSIP) but held on each execution context until it is
+// next scheduled (see Signal()).
+//
+// Guest harts are preempted at each Quantum, to prevent a hart spinning on a
+// lock or on an inter-processor request from starving the others, while their
+// machine timer and Supervisor timer interrupt state is saved and restored
+// along with each execution context. A context Handler blocking the
+// execution of its hart blocks all harts of the group.
+//
+// Each execution context follows the Hart State Management state machine,
+// secondary harts are started by the guest itself (see HartStart()) while the
+// Trusted OS waits for them to be started (see WaitStart()):
+//
+//	for {
+//		ctx.WaitStart()
+//		ctx.Run()
+//	}
+type Harts struct {
+	sync.Mutex
+
+	// Quantum is the maximum time a guest hart runs before being preempted
+	// to schedule the others, zero disables preemption.
+	Quantum time.Duration
+
+	ctx map[uint64]*ExecCtx
+	// serializes guest hart scheduling cycles
+	run sync.Mutex
+}
+
+// hartTimer represents the timer state of a guest hart.
+type hartTimer struct {
+	// machine timer compare value (mtimecmp)
+	timecmp uint64
+	// machine timer interrupt enable (mie.MTIE)
+	enabled bool
+	// Supervisor timer interrupt pending (mip.STIP)
+	pending bool
+}
+
+// NewHarts returns an empty hart group, with DefaultQuantum preemption.
+func NewHarts() *Harts {
+	return &Harts{
+		Quantum: DefaultQuantum,
+		ctx:     make(map[uint64]*ExecCtx),
+	}
+}
+
+// Add binds an execution context to the argument hart identifier, the context
+// is initialized in HartStopped state.
+func (h *Harts) Add(ctx *ExecCtx, hart uint64) (err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	if _, ok := h.ctx[hart]; ok {
+		return fmt.Errorf("hart %d already assigned", hart)
+	}
+
+	ctx.Hart = hart
+	ctx.Harts = h
+	ctx.hartState = HartStopped
+	ctx.start = make(chan struct{}, 1)
+	ctx.timer = hartTimer{timecmp: ^uint64(0)}
+
+	h.ctx[hart] = ctx
+
+	return
+}
+
+// Get returns the execution context bound to the argument hart identifier.
+func (h *Harts) Get(hart uint64) (ctx *ExecCtx, err error) {
+	h.Lock()
+	defer h.Unlock()
+
+	ctx, ok := h.ctx[hart]
+
+	if !ok {
+		return nil, fmt.Errorf("invalid hart %d", hart)
+	}
+
+	return
+}
+
+// IDs returns the sorted list of hart identifiers in the group.
+func (h *Harts) IDs() (ids []uint64) {
+	h.Lock()
+	defer h.Unlock()
+
+	for id := range h.ctx {
+		ids = append(ids, id)
+	}
+
+	slices.Sort(ids)
+
+	return
+}
+
+// switchHart gives the execution context, when part of a hart group, the
+// ownership of the current hart timer for a scheduling cycle, it returns the
+// function which saves the guest hart timer state and releases it.
+func (ctx *ExecCtx) switchHart() (release func()) {
+	h := ctx.Harts
+
+	if h == nil {
+		return func() {}
+	}
+
+	h.run.Lock()
+
+	hart := HartID()
+
+	SetTimecmp(hart, ctx.timer.timecmp)
+	EnableInterrupt(MachineTimerInterrupt, ctx.timer.enabled)
+	SetPending(SupervisorTimerInterrupt, ctx.timer.pending)
+
+	return func() {
+		ctx.timer.timecmp = Timecmp(hart)
+		ctx.timer.enabled = read_mie()&(1<<MachineTimerInterrupt) != 0
+		ctx.timer.pending = read_mip()&(1<<SupervisorTimerInterrupt) != 0
+
+		EnableInterrupt(MachineTimerInterrupt, false)
+		SetPending(SupervisorTimerInterrupt, false)
+
+		h.run.Unlock()
+	}
+}
+
+// preempted returns whether the execution context has been interrupted by
+// the hart timer at its hart group Quantum, rather than by its own timer.
+func (ctx *ExecCtx) preempted() bool {
+	if ctx.Harts == nil || ctx.Harts.Quantum <= 0 {
+		return false
+	}
+
+	if code, irq := ctx.Cause(); !irq || code != MachineTimerInterrupt {
+		return false
+	}
+
+	return read_mie()&(1<<MachineTimerInterrupt) == 0 || CLINT.Mtime() < Timecmp(HartID())
+}
+
+// lock serializes hart state transitions, within the execution context group
+// if any.
+func (ctx *ExecCtx) lock() func() {
+	if ctx.Harts == nil {
+		return func() {}
+	}
+
+	ctx.Harts.Lock()
+	return ctx.Harts.Unlock
+}
+
+// HartState returns the execution context hart state, execution contexts
+// which do not belong to a hart group are always in HartStarted state.
+func (ctx *ExecCtx) HartState() int {
+	defer ctx.lock()()
+	return ctx.hartState
+}
+
+// HartStart requests a stopped execution context to start at the argument
+// address, with its hart identifier and the opaque argument passed in A0 and
+// A1 respectively.
+func (ctx *ExecCtx) HartStart(addr uint64, opaque uint64) (err error) {
+	defer ctx.lock()()
+
+	if ctx.hartState != HartStopped || ctx.start == nil {
+		return errors.New("hart already started")
+	}
+
+	if addr < uint64(ctx.Memory.Start()) || addr >= uint64(ctx.Memory.End()) {
+		return fmt.Errorf("invalid start address %#x", addr)
+	}
+
+	ctx.PC = addr
+	ctx.X10 = ctx.Hart
+	ctx.X11 = opaque
+	ctx.hartState = HartStartPending
+
+	ctx.start <- struct{}{}
+
+	return
+}
+
+// WaitStart blocks until the execution context is requested to start (see
+// HartStart()), it is meant to be called by the Trusted OS before each Run().
+func (ctx *ExecCtx) WaitStart() {
+	if ctx.start == nil {
+		return
+	}
+
+	<-ctx.start
+
+	defer ctx.lock()()
+	ctx.hartState = HartStarted
+}
+
+// HartStop stops a started execution context, which can be subsequently
+// restarted with HartStart().
+func (ctx *ExecCtx) HartStop() (err error) {
+	unlock := ctx.lock()
+
+	if ctx.hartState != HartStarted || ctx.start == nil {
+		unlock()
+		return errors.New("hart already stopped")
+	}
+
+	ctx.hartState = HartStopped
+	unlock()
+
+	ctx.Stop()
+
+	return
+}
+
+// Signal marks inter-processor requests (RequestIPI, RequestFence) as pending
+// on the execution context, they are served before its next Schedule().
+func (ctx *ExecCtx) Signal(req int) {
+	defer ctx.lock()()
+	ctx.requests |= req
+}
+
+// serveRequests serves the pending inter-processor requests on the current
+// hart before the execution context is scheduled.
+func (ctx *ExecCtx) serveRequests() {
+	unlock := ctx.lock()
+	req := ctx.requests
+	ctx.requests = 0
+	unlock()
+
+	if req&RequestFence != 0 {
+		sfence_vma_all()
+		fence_i()
+	}
+
+	if req&RequestIPI != 0 {
+		set_mip(1 << SupervisorSoftwareInterrupt)
+	}
+}
+
+// saveRequests moves a Supervisor software interrupt, still pending once the
+// execution context yields, back to its pending requests so that it is not
+// taken by other execution contexts.
+func (ctx *ExecCtx) saveRequests() {
+	ssip := uint64(1 << SupervisorSoftwareInterrupt)
+
+	if read_mip()&ssip == 0 {
+		return
+	}
+
+	clear_mip(ssip)
+
+	defer ctx.lock()()
+	ctx.requests |= RequestIPI
+}
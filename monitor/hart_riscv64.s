@@ -0,0 +1,44 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+#include "go_asm_riscv64.h"
+
+#define FENCE_I      WORD $0x0000100f
+#define SFENCE_VMA_X WORD $0x12000073
+
+// func fence_i()
+TEXT ·fence_i(SB),NOSPLIT,$0
+	FENCE_I
+
+	RET
+
+// func sfence_vma_all()
+TEXT ·sfence_vma_all(SB),NOSPLIT,$0
+	SFENCE_VMA_X
+
+	RET
+
+// func read_mvendorid() uint64
+TEXT ·read_mvendorid(SB),NOSPLIT,$0-8
+	CSRR(mvendorid, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func read_marchid() uint64
+TEXT ·read_marchid(SB),NOSPLIT,$0-8
+	CSRR(marchid, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func read_mimpid() uint64
+TEXT ·read_mimpid(SB),NOSPLIT,$0-8
+	CSRR(mimpid, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
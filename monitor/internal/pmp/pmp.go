@@ -0,0 +1,407 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package pmp implements allocation and encoding of RISC-V Physical Memory
+// Protection entries (3.7 Physical Memory Protection, RISC-V Privileged
+// Architectures V20211203).
+//
+// The package is architecture independent, the hart PMP CSRs are accessed by
+// monitor.PMP which uses it for entry allocation on hardware as well as on
+// the host for testing.
+package pmp
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+)
+
+// Address-matching modes (pmpcfg A field), their values match
+// riscv64.PMP_A_*.
+const (
+	A_OFF = iota
+	A_TOR
+	A_NA4
+	A_NAPOT
+)
+
+// Entry represents a Physical Memory Protection entry.
+type Entry struct {
+	// Start is the region start address (inclusive)
+	Start uint64
+	// End is the region end address (exclusive)
+	End uint64
+
+	// R, W, X represent the region access permissions
+	R bool
+	W bool
+	X bool
+
+	// Lock represents the entry lock state
+	Lock bool
+
+	// A is the address-matching mode (A_OFF, A_TOR, A_NA4, A_NAPOT)
+	A int
+	// Addr is the entry address, before the 2-bit shift applied to
+	// pmpaddr registers.
+	Addr uint64
+
+	// Base flags an A_OFF entry used only as address for the following
+	// A_TOR entry.
+	Base bool
+}
+
+// Used returns whether the entry is allocated.
+func (e *Entry) Used() bool {
+	return e.A != A_OFF || e.Base
+}
+
+// Allocator represents a PMP entry allocator, entries are allocated in
+// priority order and their address-matching mode is selected automatically
+// from the region alignment.
+type Allocator struct {
+	// Entries represents the PMP entries, in priority order
+	Entries []Entry
+}
+
+// New returns a PMP entry allocator for the argument number of entries.
+func New(n int) *Allocator {
+	return &Allocator{
+		Entries: make([]Entry, n),
+	}
+}
+
+// NAPOT returns the NAPOT encoded address for the argument region, ok is
+// false if the region is not a naturally aligned power of two of at least 8
+// bytes.
+func NAPOT(start uint64, size uint64) (addr uint64, ok bool) {
+	if size < 8 || bits.OnesCount64(size) != 1 || start&(size-1) != 0 {
+		return
+	}
+
+	// the 2-bit pmpaddr shift yields trailing ones representing the
+	// region size
+	return start | (size/2 - 1), true
+}
+
+// Decode returns the region represented by an entry address and
+// address-matching mode, the previous entry address is used for TOR regions.
+func Decode(a int, addr uint64, prev uint64) (start uint64, end uint64) {
+	switch a {
+	case A_TOR:
+		return prev, addr
+	case A_NA4:
+		return addr, addr + 4
+	case A_NAPOT:
+		n := uint64(bits.TrailingZeros64(^(addr >> 2)))
+
+		if n+3 >= 64 {
+			// whole address space
+			return 0, ^uint64(0)
+		}
+
+		size := uint64(1) << (n + 3)
+		start = (addr >> 2 &^ (1<<n - 1)) << 2
+		return start, start + size
+	}
+
+	return
+}
+
+// Set sets the entry at the argument index to the argument address and
+// configuration, as read from the hart PMP CSRs.
+func (p *Allocator) Set(i int, addr uint64, r bool, w bool, x bool, a int, lock bool) (err error) {
+	if i < 0 || i >= len(p.Entries) {
+		return errors.New("invalid PMP index")
+	}
+
+	var prev uint64
+
+	if i > 0 {
+		prev = p.Entries[i-1].Addr
+	}
+
+	e := Entry{
+		R:    r,
+		W:    w,
+		X:    x,
+		Lock: lock,
+		A:    a,
+		Addr: addr,
+	}
+
+	e.Start, e.End = Decode(a, addr, prev)
+
+	if i > 0 && a == A_TOR && p.Entries[i-1].A == A_OFF {
+		p.Entries[i-1].Base = true
+	}
+
+	p.Entries[i] = e
+
+	return
+}
+
+// Next returns the first entry index after the last used one.
+func (p *Allocator) Next() (i int) {
+	for i = len(p.Entries); i > 0; i-- {
+		if p.Entries[i-1].Used() {
+			break
+		}
+	}
+
+	return
+}
+
+// available returns whether n consecutive entries, starting at the argument
+// index, are unused and not followed by a TOR entry using the last one as
+// base.
+func (p *Allocator) available(i int, n int) bool {
+	if i < 0 || i+n > len(p.Entries) {
+		return false
+	}
+
+	for j := i; j < i+n; j++ {
+		if p.Entries[j].Used() {
+			return false
+		}
+	}
+
+	if next := i + n; next < len(p.Entries) && p.Entries[next].A == A_TOR {
+		return false
+	}
+
+	return true
+}
+
+// free returns the first index of n consecutive available entries.
+func (p *Allocator) free(n int) (i int, err error) {
+	for i = 0; i < len(p.Entries); i++ {
+		if p.available(i, n) {
+			return
+		}
+	}
+
+	return -1, errors.New("no PMP entries available")
+}
+
+// reuse returns whether the entry at the argument index can hold a TOR entry
+// starting at the argument address without an additional base entry.
+func (p *Allocator) reuse(i int, start uint64) bool {
+	if !p.available(i, 1) {
+		return false
+	}
+
+	if i == 0 {
+		return start == 0
+	}
+
+	prev := p.Entries[i-1]
+
+	return prev.Addr == start && (prev.A == A_TOR || prev.Base)
+}
+
+// tor returns the index of the first available entry which can hold a TOR
+// entry starting at the argument address, base is true if it requires an
+// additional base entry, which precedes it.
+func (p *Allocator) tor(start uint64) (i int, base bool, err error) {
+	for i = 0; i < len(p.Entries); i++ {
+		if p.reuse(i, start) {
+			return
+		}
+	}
+
+	if i, err = p.free(2); err != nil {
+		return
+	}
+
+	return i + 1, true, nil
+}
+
+// encode returns the entry for the argument region and access permissions.
+func encode(start uint64, end uint64, r bool, w bool, x bool) (e Entry, err error) {
+	if end <= start || start%4 != 0 || end%4 != 0 {
+		return e, fmt.Errorf("invalid PMP region %#x-%#x", start, end)
+	}
+
+	e = Entry{
+		Start: start,
+		End:   end,
+		R:     r,
+		W:     w,
+		X:     x,
+	}
+
+	switch addr, ok := NAPOT(start, end-start); {
+	case end-start == 4:
+		e.A = A_NA4
+		e.Addr = start
+	case ok:
+		e.A = A_NAPOT
+		e.Addr = addr
+	default:
+		e.A = A_TOR
+		e.Addr = end
+	}
+
+	return
+}
+
+// set places the argument entry, after verifying that it does not overlap
+// any entry of higher priority.
+func (p *Allocator) set(i int, e Entry, base bool) (err error) {
+	for j, prev := range p.Entries[:i] {
+		if prev.A != A_OFF && e.Start < prev.End && prev.Start < e.End {
+			return fmt.Errorf("PMP region %#x-%#x overlaps higher priority entry %d", e.Start, e.End, j)
+		}
+	}
+
+	if base {
+		p.Entries[i-1] = Entry{
+			Addr: e.Start,
+			Base: true,
+		}
+	}
+
+	p.Entries[i] = e
+
+	return
+}
+
+// Add allocates PMP entries for the argument region and access permissions,
+// returning the index of the entry that matches it.
+//
+// NAPOT or NA4 encoding is used for naturally aligned regions, otherwise TOR
+// encoding is used which requires an additional entry unless the previous one
+// ends at the region start.
+//
+// Entries are allocated at the first available index, an error is returned
+// if the region overlaps with any entry of higher priority, as it would take
+// precedence. Overlapping lower priority entries (see AddAt()) is allowed.
+func (p *Allocator) Add(start uint64, end uint64, r bool, w bool, x bool) (i int, err error) {
+	var base bool
+
+	e, err := encode(start, end, r, w, x)
+
+	if err != nil {
+		return -1, err
+	}
+
+	if e.A == A_TOR {
+		i, base, err = p.tor(start)
+	} else {
+		i, err = p.free(1)
+	}
+
+	if err != nil {
+		return -1, err
+	}
+
+	if err = p.set(i, e, base); err != nil {
+		return -1, err
+	}
+
+	return
+}
+
+// AddAt allocates PMP entries for the argument region and access permissions
+// at the argument index, which becomes the index of the entry that matches
+// it, TOR encoded regions might require an additional entry preceding it.
+//
+// It allows to place entries at a lower priority than subsequent Add()
+// allocations, such as locked entries granting machine mode access to all
+// memory (see monitor.PMP.SetSecurityConfig()).
+func (p *Allocator) AddAt(i int, start uint64, end uint64, r bool, w bool, x bool) (err error) {
+	var base bool
+
+	e, err := encode(start, end, r, w, x)
+
+	if err != nil {
+		return
+	}
+
+	switch {
+	case e.A != A_TOR || p.reuse(i, start):
+		if !p.available(i, 1) {
+			return fmt.Errorf("PMP entry %d not available", i)
+		}
+	case p.available(i-1, 2):
+		base = true
+	default:
+		return fmt.Errorf("PMP entries %d-%d not available", i-1, i)
+	}
+
+	return p.set(i, e, base)
+}
+
+// Lock locks the entry at the argument index.
+//
+// Locking a TOR entry also locks its base entry address.
+func (p *Allocator) Lock(i int) (err error) {
+	if i < 0 || i >= len(p.Entries) || p.Entries[i].A == A_OFF {
+		return errors.New("invalid PMP index")
+	}
+
+	p.Entries[i].Lock = true
+
+	return
+}
+
+// Locked returns whether the entry at the argument index is locked, either
+// directly or as base of a locked TOR entry.
+func (p *Allocator) Locked(i int) bool {
+	if p.Entries[i].Lock {
+		return true
+	}
+
+	if next := i + 1; p.Entries[i].Used() && next < len(p.Entries) {
+		return p.Entries[next].Lock && p.Entries[next].A == A_TOR
+	}
+
+	return false
+}
+
+// Remove frees the entry at the argument index, locked entries can only be
+// removed when bypass is true.
+//
+// An entry used as base by the following TOR entry is retained as its base.
+func (p *Allocator) Remove(i int, bypass bool) (err error) {
+	if i < 0 || i >= len(p.Entries) {
+		return errors.New("invalid PMP index")
+	}
+
+	if p.Locked(i) && !bypass {
+		return errors.New("PMP entry is locked")
+	}
+
+	p.remove(i)
+
+	return
+}
+
+func (p *Allocator) remove(i int) {
+	if p.Entries[i].A == A_TOR && i > 0 && p.Entries[i-1].Base {
+		p.Entries[i-1] = Entry{}
+	}
+
+	if next := i + 1; next < len(p.Entries) && p.Entries[next].A == A_TOR {
+		p.Entries[i] = Entry{
+			Addr: p.Entries[i].Addr,
+			Base: true,
+		}
+
+		return
+	}
+
+	p.Entries[i] = Entry{}
+}
+
+// Reset frees all unlocked entries.
+func (p *Allocator) Reset() {
+	for i := len(p.Entries) - 1; i >= 0; i-- {
+		if !p.Locked(i) {
+			p.remove(i)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package pmp
+
+import (
+	"testing"
+)
+
+func TestNAPOT(t *testing.T) {
+	tests := []struct {
+		start uint64
+		size  uint64
+		addr  uint64
+		ok    bool
+	}{
+		{0x80000000, 8, 0x80000003, true},
+		{0x80000000, 0x1000, 0x800007ff, true},
+		{0x80000000, 0x10000000, 0x87ffffff, true},
+		{0, 1 << 63, 1<<62 - 1, true},
+		{0x80000000, 4, 0, false},
+		{0x80000000, 0x3000, 0, false},
+		{0x80001000, 0x2000, 0, false},
+	}
+
+	for _, tt := range tests {
+		addr, ok := NAPOT(tt.start, tt.size)
+
+		if ok != tt.ok || addr != tt.addr {
+			t.Errorf("NAPOT(%#x, %#x) = %#x, %v, want %#x, %v", tt.start, tt.size, addr, ok, tt.addr, tt.ok)
+		}
+
+		if !ok {
+			continue
+		}
+
+		// the pmpaddr register read back drops the 2 least significant bits
+		if start, end := Decode(A_NAPOT, addr>>2<<2, 0); start != tt.start || end != tt.start+tt.size {
+			t.Errorf("Decode(NAPOT, %#x) = %#x-%#x, want %#x-%#x", addr, start, end, tt.start, tt.start+tt.size)
+		}
+	}
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		a     int
+		addr  uint64
+		prev  uint64
+		start uint64
+		end   uint64
+	}{
+		{"off", A_OFF, 0x1000, 0, 0, 0},
+		{"tor", A_TOR, 0x3000, 0x1000, 0x1000, 0x3000},
+		{"na4", A_NA4, 0x1000, 0, 0x1000, 0x1004},
+		{"napot 8", A_NAPOT, 0x1000, 0, 0x1000, 0x1008},
+		{"napot 16", A_NAPOT, 0x1004, 0, 0x1000, 0x1010},
+		{"napot all", A_NAPOT, ^uint64(0), 0, 0, ^uint64(0)},
+	}
+
+	for _, tt := range tests {
+		if start, end := Decode(tt.a, tt.addr, tt.prev); start != tt.start || end != tt.end {
+			t.Errorf("%s: Decode() = %#x-%#x, want %#x-%#x", tt.name, start, end, tt.start, tt.end)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	type region struct {
+		start uint64
+		end   uint64
+	}
+
+	tests := []struct {
+		name    string
+		regions []region
+		index   []int
+		modes   []int
+		used    int
+		err     bool
+	}{
+		{
+			name:    "napot",
+			regions: []region{{0x80000000, 0x80100000}},
+			index:   []int{0},
+			modes:   []int{A_NAPOT},
+			used:    1,
+		},
+		{
+			name:    "na4",
+			regions: []region{{0x10000000, 0x10000004}},
+			index:   []int{0},
+			modes:   []int{A_NA4},
+			used:    1,
+		},
+		{
+			name:    "tor with base",
+			regions: []region{{0x80001000, 0x80004000}},
+			index:   []int{1},
+			modes:   []int{A_OFF, A_TOR},
+			used:    2,
+		},
+		{
+			name:    "tor from zero",
+			regions: []region{{0, 0x3000}},
+			index:   []int{0},
+			modes:   []int{A_TOR},
+			used:    1,
+		},
+		{
+			name:    "adjacent tor reuses base",
+			regions: []region{{0x1000, 0x4000}, {0x4000, 0x7000}},
+			index:   []int{1, 2},
+			modes:   []int{A_OFF, A_TOR, A_TOR},
+			used:    3,
+		},
+		{
+			name:    "overlap",
+			regions: []region{{0x80000000, 0x80100000}, {0x800ff000, 0x80101000}},
+			err:     true,
+		},
+		{
+			name:    "invalid range",
+			regions: []region{{0x2000, 0x1000}},
+			err:     true,
+		},
+		{
+			name:    "unaligned",
+			regions: []region{{0x1001, 0x2000}},
+			err:     true,
+		},
+		{
+			name: "exhausted",
+			regions: []region{
+				{0x1000, 0x2000}, {0x3000, 0x4000}, {0x5000, 0x6000}, {0x7000, 0x8000},
+				{0x9000, 0xa000}, {0xb000, 0xc000}, {0xd000, 0xe000}, {0xf000, 0x10000},
+				{0x11000, 0x12000},
+			},
+			err: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(8)
+
+			var err error
+
+			for i, r := range tt.regions {
+				var n int
+
+				if n, err = p.Add(r.start, r.end, true, false, true); err != nil {
+					break
+				}
+
+				if i < len(tt.index) && n != tt.index[i] {
+					t.Errorf("region %d index = %d, want %d", i, n, tt.index[i])
+				}
+
+				e := p.Entries[n]
+
+				if e.Start != r.start || e.End != r.end || !e.R || e.W || !e.X {
+					t.Errorf("region %d entry = %+v", i, e)
+				}
+			}
+
+			if tt.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, a := range tt.modes {
+				if p.Entries[i].A != a {
+					t.Errorf("entry %d mode = %d, want %d", i, p.Entries[i].A, a)
+				}
+			}
+
+			if n := p.Next(); n != tt.used {
+				t.Errorf("next = %d, want %d", n, tt.used)
+			}
+
+			// verify hardware encoding of TOR entries
+			var prev uint64
+
+			for i, e := range p.Entries {
+				if e.A != A_OFF {
+					if start, end := Decode(e.A, e.Addr, prev); start != e.Start || end != e.End {
+						t.Errorf("entry %d decodes to %#x-%#x, want %#x-%#x", i, start, end, e.Start, e.End)
+					}
+				}
+
+				prev = e.Addr
+			}
+		})
+	}
+}
+
+func TestAddLockedLowPriority(t *testing.T) {
+	p := New(8)
+
+	// locked machine mode entry for all RAM, at lowest priority
+	if err := p.AddAt(7, 0x80000000, 0xc0000000, true, true, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.Lock(7); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := p.Entries[7]; e.A != A_NAPOT || e.Start != 0x80000000 || e.End != 0xc0000000 {
+		t.Errorf("entry = %+v", e)
+	}
+
+	p.Reset()
+
+	i, err := p.Add(0x80000000, 0x80100000, true, true, false)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if i != 0 {
+		t.Errorf("index = %d, want 0", i)
+	}
+
+	// overlapping a higher priority locked entry is rejected
+	if err := p.Set(0, 0x80000000|0x7ffff, false, false, false, A_NAPOT, true); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Reset()
+
+	if _, err := p.Add(0x80000000, 0x80100000, true, true, false); err == nil {
+		t.Error("expected overlap error")
+	}
+}
+
+func TestAddAt(t *testing.T) {
+	p := New(8)
+
+	// TOR entry requiring a base entry
+	if err := p.AddAt(7, 0x80001000, 0x80004000, true, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Entries[6].Base || p.Entries[6].Addr != 0x80001000 || p.Entries[7].A != A_TOR {
+		t.Errorf("entries = %+v", p.Entries[6:])
+	}
+
+	// the first entry cannot have a base entry
+	if err := p.AddAt(0, 0x1000, 0x3000, true, false, false); err == nil {
+		t.Error("expected unavailable base error")
+	}
+
+	if err := p.AddAt(1, 0x1000, 0x2000, true, false, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.AddAt(1, 0x4000, 0x5000, true, false, false); err == nil {
+		t.Error("expected used entry error")
+	}
+
+	// a NAPOT entry address cannot be used as TOR base
+	if err := p.AddAt(2, 0x2000, 0x5000, true, false, false); err == nil {
+		t.Error("expected unavailable base error")
+	}
+
+	// a higher priority entry overlapping a lower one is allowed
+	if i, err := p.Add(0x80000000, 0x80100000, true, true, false); err != nil || i != 0 {
+		t.Errorf("index = %d, err = %v, want 0", i, err)
+	}
+
+	// a lower priority entry overlapping a higher one is not
+	if err := p.AddAt(4, 0x80000000, 0x80001000, true, false, false); err == nil {
+		t.Error("expected overlap error")
+	}
+
+	if err := p.AddAt(8, 0x1000, 0x2000, true, false, false); err == nil {
+		t.Error("expected invalid index error")
+	}
+}
+
+func TestAddGaps(t *testing.T) {
+	p := New(8)
+
+	// a locked entry at the highest index must not exhaust the allocator
+	p.Set(6, 0x20000000, false, false, false, A_OFF, false)
+	p.Set(7, 0x20001000, true, false, false, A_TOR, true)
+
+	for n := 0; n < 5; n++ {
+		i, err := p.Add(uint64(n)*0x1000, uint64(n)*0x1000+0x1000, true, false, false)
+
+		if err != nil {
+			t.Fatalf("region %d: %v", n, err)
+		}
+
+		if i != n {
+			t.Errorf("region %d index = %d, want %d", n, i, n)
+		}
+	}
+
+	// the entry preceding a TOR base is still available
+	if i, err := p.Add(0x10000000, 0x10001000, true, false, false); err != nil || i != 5 {
+		t.Errorf("index = %d, err = %v, want 5", i, err)
+	}
+
+	if _, err := p.Add(0x30000000, 0x30001000, true, false, false); err == nil {
+		t.Error("expected exhaustion error")
+	}
+
+	// removed entries are reused
+	if err := p.Remove(2, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if i, err := p.Add(0x30000000, 0x30001000, true, false, false); err != nil || i != 2 {
+		t.Errorf("index = %d, err = %v, want 2", i, err)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	p := New(8)
+
+	a, _ := p.Add(0x1000, 0x4000, true, true, false)
+	b, _ := p.Add(0x4000, 0x7000, true, true, false)
+
+	if a != 1 || b != 2 {
+		t.Fatalf("indices = %d, %d, want 1, 2", a, b)
+	}
+
+	// removing a TOR entry used as base retains its address
+	if err := p.Remove(a, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if e := p.Entries[a]; !e.Base || e.Addr != 0x4000 || e.A != A_OFF {
+		t.Errorf("removed entry = %+v, want base at 0x4000", e)
+	}
+
+	if start, end := Decode(A_TOR, p.Entries[b].Addr, p.Entries[a].Addr); start != 0x4000 || end != 0x7000 {
+		t.Errorf("remaining entry decodes to %#x-%#x", start, end)
+	}
+
+	// removing the last TOR entry releases its base
+	if err := p.Remove(b, false); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Next() != 0 {
+		t.Errorf("next = %d, want 0", p.Next())
+	}
+
+	if err := p.Remove(8, false); err == nil {
+		t.Error("expected invalid index error")
+	}
+}
+
+func TestLock(t *testing.T) {
+	p := New(8)
+
+	i, _ := p.Add(0x1000, 0x4000, true, false, false)
+
+	if err := p.Lock(i); err != nil {
+		t.Fatal(err)
+	}
+
+	if !p.Locked(i) || !p.Locked(i-1) {
+		t.Error("TOR entry and base must be locked")
+	}
+
+	if err := p.Remove(i-1, false); err == nil {
+		t.Error("expected locked base error")
+	}
+
+	if err := p.Remove(i, false); err == nil {
+		t.Error("expected locked entry error")
+	}
+
+	j, _ := p.Add(0x80000000, 0x80001000, true, true, false)
+
+	p.Reset()
+
+	if p.Entries[j].Used() || !p.Entries[i].Used() || !p.Entries[i-1].Base {
+		t.Errorf("reset entries = %+v", p.Entries)
+	}
+
+	// rule locking bypass
+	if err := p.Remove(i, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Next() != 0 {
+		t.Errorf("next = %d, want 0", p.Next())
+	}
+
+	if err := p.Lock(5); err == nil {
+		t.Error("expected invalid index error on unused entry")
+	}
+}
+
+func TestSet(t *testing.T) {
+	p := New(8)
+
+	p.Set(0, 0x80000000, false, false, false, A_OFF, false)
+	p.Set(1, 0x80200000, true, true, true, A_TOR, true)
+
+	if !p.Entries[0].Base {
+		t.Error("TOR base not detected")
+	}
+
+	if e := p.Entries[1]; e.Start != 0x80000000 || e.End != 0x80200000 {
+		t.Errorf("entry = %#x-%#x", e.Start, e.End)
+	}
+
+	if err := p.Set(8, 0, false, false, false, A_OFF, false); err == nil {
+		t.Error("expected invalid index error")
+	}
+}
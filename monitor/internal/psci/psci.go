@@ -0,0 +1,350 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package psci implements an Arm Power State Coordination Interface service
+// (DEN0022 - Arm Power State Coordination Interface), to be registered on an
+// SMC Calling Convention dispatcher (see smccc.Dispatcher).
+//
+// The package is architecture independent, execution contexts are abstracted
+// through the Context interface, it is therefore used by monitor.PSCI on
+// hardware as well as on the host for testing.
+package psci
+
+import (
+	"sync"
+
+	"github.com/usbarmory/GoTEE/smccc"
+)
+
+// PSCI version implemented by the PSCI service.
+const (
+	PSCI_MAJOR = 1
+	PSCI_MINOR = 1
+)
+
+// PSCI Function IDs (SMC32)
+// (5.1 Function prototypes, DEN0022 - Arm Power State Coordination Interface).
+const (
+	PSCI_VERSION           = 0x84000000
+	PSCI_CPU_SUSPEND       = 0x84000001
+	PSCI_CPU_OFF           = 0x84000002
+	PSCI_CPU_ON            = 0x84000003
+	PSCI_AFFINITY_INFO     = 0x84000004
+	PSCI_MIGRATE_INFO_TYPE = 0x84000006
+	PSCI_SYSTEM_OFF        = 0x84000008
+	PSCI_SYSTEM_RESET      = 0x84000009
+	PSCI_FEATURES          = 0x8400000a
+)
+
+// PSCI return codes
+// (5.2.2 Return error codes, DEN0022).
+const (
+	PSCI_SUCCESS            = 0
+	PSCI_NOT_SUPPORTED      = -1
+	PSCI_INVALID_PARAMETERS = -2
+	PSCI_DENIED             = -3
+	PSCI_ALREADY_ON         = -4
+	PSCI_ON_PENDING         = -5
+	PSCI_INTERNAL_FAILURE   = -6
+	PSCI_NOT_PRESENT        = -7
+	PSCI_DISABLED           = -8
+	PSCI_INVALID_ADDRESS    = -9
+)
+
+// PSCI affinity states (AFFINITY_INFO)
+const (
+	PSCI_AFFINITY_ON         = 0
+	PSCI_AFFINITY_OFF        = 1
+	PSCI_AFFINITY_ON_PENDING = 2
+)
+
+// PSCI_MIGRATE_INFO_TYPE value for Trusted OS which do not require migration.
+const migrateNotRequired = 2
+
+// Context represents the execution context of a core.
+type Context interface {
+	comparable
+
+	// Stop stops the execution context.
+	Stop()
+	// MemoryRegion returns the offset of the argument address within the
+	// execution context memory, an error is returned if the range
+	// exceeds it.
+	MemoryRegion(addr uint, n int) (off int, err error)
+}
+
+// Service represents a Power State Coordination Interface service for
+// non-secure execution contexts, to let Normal World kernels (e.g. Linux)
+// manage cores and system power states without modification.
+//
+// Cores are identified by the MPIDR affinity level 0 field. Core and system
+// power operations are delegated to the Trusted OS through the Service
+// callbacks, operations without a callback are not supported.
+type Service[T Context] struct {
+	sync.Mutex
+
+	// CPUs is the number of cores available to the Normal World, a single
+	// core is assumed if not set.
+	CPUs int
+
+	// Primary is the core 0 execution context, if not set the first
+	// execution context to invoke the service is recorded as such.
+	Primary T
+
+	// CPUOn, if not nil, is invoked to start a secondary core at the
+	// argument entry point with the argument context ID in R0, it returns
+	// the execution context which the Trusted OS is responsible for
+	// running on the core.
+	CPUOn func(ctx T, cpu int, entry uint32, contextID uint32) (T, error)
+
+	// CPUOff, if not nil, is invoked to power down the core of the calling
+	// execution context, which is stopped if no error is returned.
+	CPUOff func(ctx T) error
+
+	// SystemOff, if not nil, is invoked on system shutdown requests.
+	SystemOff func(ctx T) error
+
+	// SystemReset, if not nil, is invoked on system reset requests.
+	SystemReset func(ctx T) error
+
+	// execution contexts of powered on cores, indexed by core
+	cores map[int]T
+}
+
+// Register adds all PSCI functions to the argument dispatcher.
+func (p *Service[T]) Register(d *smccc.Dispatcher) (err error) {
+	functions := map[smccc.FunctionID]smccc.Handler{
+		PSCI_VERSION:           p.version,
+		PSCI_CPU_SUSPEND:       p.cpuSuspend,
+		PSCI_CPU_OFF:           p.cpuOff,
+		PSCI_CPU_ON:            p.cpuOn,
+		PSCI_AFFINITY_INFO:     p.affinityInfo,
+		PSCI_MIGRATE_INFO_TYPE: p.migrateInfoType,
+		PSCI_SYSTEM_OFF:        p.systemOff,
+		PSCI_SYSTEM_RESET:      p.systemReset,
+		PSCI_FEATURES:          p.features,
+	}
+
+	for id, h := range functions {
+		if err = d.Register(id, h); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// core returns the core index of the calling execution context, the first
+// caller is recorded as Primary if not set.
+func (p *Service[T]) core(ctx T) (cpu int, ok bool) {
+	var none T
+
+	if p.Primary == none {
+		p.Primary = ctx
+	}
+
+	if p.cores == nil {
+		p.cores = map[int]T{0: p.Primary}
+	}
+
+	for cpu, c := range p.cores {
+		if c == ctx {
+			return cpu, true
+		}
+	}
+
+	return -1, false
+}
+
+func (p *Service[T]) cpus() int {
+	if p.CPUs <= 0 {
+		return 1
+	}
+
+	return p.CPUs
+}
+
+func (p *Service[T]) version(call *smccc.Call) error {
+	call.Return(PSCI_MAJOR<<16 | PSCI_MINOR)
+	return nil
+}
+
+// cpuSuspend returns immediately, as if woken up before entering the
+// requested power state, which is permitted for both standby and power down
+// states.
+func (p *Service[T]) cpuSuspend(call *smccc.Call) error {
+	call.Return(PSCI_SUCCESS)
+	return nil
+}
+
+func (p *Service[T]) cpuOff(call *smccc.Call) (err error) {
+	ctx := call.Context.(T)
+
+	p.Lock()
+	defer p.Unlock()
+
+	cpu, ok := p.core(ctx)
+
+	if !ok || p.CPUOff == nil {
+		call.Return(PSCI_DENIED)
+		return
+	}
+
+	if err = p.CPUOff(ctx); err != nil {
+		call.Return(PSCI_DENIED)
+		return nil
+	}
+
+	delete(p.cores, cpu)
+	ctx.Stop()
+
+	// the call does not return unless the context is resumed
+	call.Return(PSCI_SUCCESS)
+
+	return
+}
+
+func (p *Service[T]) cpuOn(call *smccc.Call) (err error) {
+	var none T
+
+	ctx := call.Context.(T)
+	cpu := int(call.Regs[1] & 0xff)
+	entry := uint32(call.Regs[2])
+	contextID := uint32(call.Regs[3])
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.core(ctx)
+
+	_, on := p.cores[cpu]
+
+	switch {
+	case call.Regs[1]&^0xff != 0 || cpu >= p.cpus():
+		call.Return(PSCI_INVALID_PARAMETERS)
+		return
+	case on:
+		call.Return(PSCI_ALREADY_ON)
+		return
+	case p.CPUOn == nil:
+		call.Return(PSCI_NOT_SUPPORTED)
+		return
+	}
+
+	if _, err = ctx.MemoryRegion(uint(entry), 0); err != nil {
+		call.Return(PSCI_INVALID_ADDRESS)
+		return nil
+	}
+
+	c, err := p.CPUOn(ctx, cpu, entry, contextID)
+
+	if err != nil || c == none {
+		call.Return(PSCI_INTERNAL_FAILURE)
+		return nil
+	}
+
+	if cpu == 0 {
+		p.Primary = c
+	}
+
+	p.cores[cpu] = c
+	call.Return(PSCI_SUCCESS)
+
+	return
+}
+
+func (p *Service[T]) affinityInfo(call *smccc.Call) error {
+	ctx := call.Context.(T)
+	cpu := int(call.Regs[1] & 0xff)
+	level := call.Regs[2]
+
+	p.Lock()
+	defer p.Unlock()
+
+	p.core(ctx)
+
+	_, on := p.cores[cpu]
+
+	switch {
+	case call.Regs[1]&^0xff != 0 || cpu >= p.cpus() || level != 0:
+		call.Return(PSCI_INVALID_PARAMETERS)
+	case on:
+		call.Return(PSCI_AFFINITY_ON)
+	default:
+		call.Return(PSCI_AFFINITY_OFF)
+	}
+
+	return nil
+}
+
+func (p *Service[T]) migrateInfoType(call *smccc.Call) error {
+	call.Return(migrateNotRequired)
+	return nil
+}
+
+func (p *Service[T]) systemOff(call *smccc.Call) error {
+	if p.SystemOff == nil {
+		call.Return(PSCI_NOT_SUPPORTED)
+		return nil
+	}
+
+	if err := p.SystemOff(call.Context.(T)); err != nil {
+		call.Return(PSCI_DENIED)
+		return nil
+	}
+
+	// the call does not return unless the context is resumed
+	call.Return(PSCI_SUCCESS)
+
+	return nil
+}
+
+func (p *Service[T]) systemReset(call *smccc.Call) error {
+	if p.SystemReset == nil {
+		call.Return(PSCI_NOT_SUPPORTED)
+		return nil
+	}
+
+	if err := p.SystemReset(call.Context.(T)); err != nil {
+		call.Return(PSCI_DENIED)
+		return nil
+	}
+
+	// the call does not return unless the context is resumed
+	call.Return(PSCI_SUCCESS)
+
+	return nil
+}
+
+// features reports implemented PSCI functions, as well as SMCCC_VERSION
+// (5.1.14 PSCI_FEATURES, DEN0022).
+func (p *Service[T]) features(call *smccc.Call) error {
+	switch id := uint32(call.Regs[1]); id {
+	case smccc.SMCCC_VERSION, PSCI_VERSION, PSCI_CPU_SUSPEND,
+		PSCI_AFFINITY_INFO, PSCI_MIGRATE_INFO_TYPE, PSCI_FEATURES:
+		// original power state format, no OS-initiated mode
+		call.Return(PSCI_SUCCESS)
+	case PSCI_CPU_ON:
+		p.supported(call, p.CPUOn != nil)
+	case PSCI_CPU_OFF:
+		p.supported(call, p.CPUOff != nil)
+	case PSCI_SYSTEM_OFF:
+		p.supported(call, p.SystemOff != nil)
+	case PSCI_SYSTEM_RESET:
+		p.supported(call, p.SystemReset != nil)
+	default:
+		call.Return(PSCI_NOT_SUPPORTED)
+	}
+
+	return nil
+}
+
+func (p *Service[T]) supported(call *smccc.Call, ok bool) {
+	if ok {
+		call.Return(PSCI_SUCCESS)
+	} else {
+		call.Return(PSCI_NOT_SUPPORTED)
+	}
+}
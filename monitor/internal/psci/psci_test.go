@@ -0,0 +1,300 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package psci
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/usbarmory/GoTEE/smccc"
+)
+
+const (
+	testStart = 0x10000000
+	testSize  = 0x100000
+)
+
+// testContext is a core execution context with a fixed memory range.
+type testContext struct {
+	name    string
+	stopped bool
+}
+
+func (c *testContext) Stop() {
+	c.stopped = true
+}
+
+func (c *testContext) MemoryRegion(addr uint, n int) (off int, err error) {
+	off = int(addr) - testStart
+
+	if off < 0 || n < 0 || off+n > testSize || off >= testSize {
+		return 0, errors.New("invalid offset")
+	}
+
+	return
+}
+
+func newService(t *testing.T, p *Service[*testContext]) *smccc.Dispatcher {
+	t.Helper()
+
+	d := smccc.NewDispatcher()
+
+	if err := p.Register(d); err != nil {
+		t.Fatal(err)
+	}
+
+	return d
+}
+
+// call dispatches a PSCI function from the argument context and returns its
+// result.
+func call(t *testing.T, d *smccc.Dispatcher, ctx *testContext, id uint32, args ...uint64) int64 {
+	t.Helper()
+
+	c := &smccc.Call{Context: ctx}
+	c.Regs[0] = uint64(id)
+	copy(c.Regs[1:], args)
+
+	if err := d.Dispatch(c); err != nil {
+		t.Fatalf("%#x: %v", id, err)
+	}
+
+	return int64(int32(c.Regs[0]))
+}
+
+func TestRouting(t *testing.T) {
+	reset := false
+
+	p := &Service[*testContext]{
+		CPUs: 2,
+		SystemReset: func(ctx *testContext) error {
+			reset = true
+			return nil
+		},
+	}
+
+	d := newService(t, p)
+	ctx := &testContext{name: "core0"}
+
+	tests := []struct {
+		name string
+		id   uint32
+		args []uint64
+		ret  int64
+	}{
+		{"version", PSCI_VERSION, nil, PSCI_MAJOR<<16 | PSCI_MINOR},
+		{"suspend", PSCI_CPU_SUSPEND, nil, PSCI_SUCCESS},
+		{"affinity on", PSCI_AFFINITY_INFO, []uint64{0, 0}, PSCI_AFFINITY_ON},
+		{"affinity off", PSCI_AFFINITY_INFO, []uint64{1, 0}, PSCI_AFFINITY_OFF},
+		{"affinity invalid core", PSCI_AFFINITY_INFO, []uint64{2, 0}, PSCI_INVALID_PARAMETERS},
+		{"affinity invalid level", PSCI_AFFINITY_INFO, []uint64{0, 1}, PSCI_INVALID_PARAMETERS},
+		{"migrate info type", PSCI_MIGRATE_INFO_TYPE, nil, migrateNotRequired},
+		{"cpu on without callback", PSCI_CPU_ON, []uint64{1, testStart, 0}, PSCI_NOT_SUPPORTED},
+		{"cpu off without callback", PSCI_CPU_OFF, nil, PSCI_DENIED},
+		{"system off without callback", PSCI_SYSTEM_OFF, nil, PSCI_NOT_SUPPORTED},
+		{"system reset", PSCI_SYSTEM_RESET, nil, PSCI_SUCCESS},
+		{"unimplemented", 0x84000005, nil, PSCI_NOT_SUPPORTED},
+	}
+
+	for _, tt := range tests {
+		if ret := call(t, d, ctx, tt.id, tt.args...); ret != tt.ret {
+			t.Errorf("%s: ret = %d, want %d", tt.name, ret, tt.ret)
+		}
+	}
+
+	if !reset {
+		t.Error("SystemReset not invoked")
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	on := func(ctx *testContext, cpu int, entry uint32, contextID uint32) (*testContext, error) {
+		return &testContext{}, nil
+	}
+
+	off := func(ctx *testContext) error {
+		return nil
+	}
+
+	functions := []uint32{
+		smccc.SMCCC_VERSION,
+		PSCI_VERSION,
+		PSCI_CPU_SUSPEND,
+		PSCI_CPU_OFF,
+		PSCI_CPU_ON,
+		PSCI_AFFINITY_INFO,
+		PSCI_MIGRATE_INFO_TYPE,
+		PSCI_SYSTEM_OFF,
+		PSCI_SYSTEM_RESET,
+		PSCI_FEATURES,
+		0x84000005,
+	}
+
+	tests := []struct {
+		name    string
+		service *Service[*testContext]
+		want    []int64
+	}{
+		{
+			name:    "no callbacks",
+			service: &Service[*testContext]{},
+			want: []int64{
+				PSCI_SUCCESS, PSCI_SUCCESS, PSCI_SUCCESS,
+				PSCI_NOT_SUPPORTED, PSCI_NOT_SUPPORTED,
+				PSCI_SUCCESS, PSCI_SUCCESS,
+				PSCI_NOT_SUPPORTED, PSCI_NOT_SUPPORTED,
+				PSCI_SUCCESS, PSCI_NOT_SUPPORTED,
+			},
+		},
+		{
+			name: "all callbacks",
+			service: &Service[*testContext]{
+				CPUOn:       on,
+				CPUOff:      off,
+				SystemOff:   off,
+				SystemReset: off,
+			},
+			want: []int64{
+				PSCI_SUCCESS, PSCI_SUCCESS, PSCI_SUCCESS,
+				PSCI_SUCCESS, PSCI_SUCCESS,
+				PSCI_SUCCESS, PSCI_SUCCESS,
+				PSCI_SUCCESS, PSCI_SUCCESS,
+				PSCI_SUCCESS, PSCI_NOT_SUPPORTED,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		d := newService(t, tt.service)
+
+		for i, id := range functions {
+			if ret := call(t, d, &testContext{}, PSCI_FEATURES, uint64(id)); ret != tt.want[i] {
+				t.Errorf("%s: features(%#x) = %d, want %d", tt.name, id, ret, tt.want[i])
+			}
+		}
+	}
+}
+
+func TestCPUOn(t *testing.T) {
+	var started []int
+
+	primary := &testContext{name: "core0"}
+
+	p := &Service[*testContext]{
+		CPUs:    4,
+		Primary: primary,
+		CPUOn: func(ctx *testContext, cpu int, entry uint32, contextID uint32) (*testContext, error) {
+			if cpu == 3 {
+				return nil, errors.New("failure")
+			}
+
+			started = append(started, cpu)
+
+			return &testContext{name: "secondary"}, nil
+		},
+	}
+
+	d := newService(t, p)
+
+	tests := []struct {
+		name string
+		args []uint64
+		ret  int64
+	}{
+		{"secondary", []uint64{1, testStart, 0}, PSCI_SUCCESS},
+		{"already on", []uint64{1, testStart, 0}, PSCI_ALREADY_ON},
+		{"primary already on", []uint64{0, testStart, 0}, PSCI_ALREADY_ON},
+		{"invalid core", []uint64{4, testStart, 0}, PSCI_INVALID_PARAMETERS},
+		{"invalid affinity", []uint64{1 << 8, testStart, 0}, PSCI_INVALID_PARAMETERS},
+		{"invalid entry", []uint64{2, testStart + testSize, 0}, PSCI_INVALID_ADDRESS},
+		{"callback failure", []uint64{3, testStart, 0}, PSCI_INTERNAL_FAILURE},
+	}
+
+	for _, tt := range tests {
+		if ret := call(t, d, primary, PSCI_CPU_ON, tt.args...); ret != tt.ret {
+			t.Errorf("%s: ret = %d, want %d", tt.name, ret, tt.ret)
+		}
+	}
+
+	if len(started) != 1 || started[0] != 1 {
+		t.Errorf("started = %v, want [1]", started)
+	}
+
+	if ret := call(t, d, primary, PSCI_AFFINITY_INFO, 1, 0); ret != PSCI_AFFINITY_ON {
+		t.Errorf("affinity = %d, want on", ret)
+	}
+}
+
+func TestCore(t *testing.T) {
+	var secondary *testContext
+
+	p := &Service[*testContext]{
+		CPUs: 2,
+		CPUOn: func(ctx *testContext, cpu int, entry uint32, contextID uint32) (*testContext, error) {
+			c := &testContext{name: "core"}
+
+			if cpu == 1 {
+				secondary = c
+			}
+
+			return c, nil
+		},
+		CPUOff: func(ctx *testContext) error {
+			return nil
+		},
+	}
+
+	d := newService(t, p)
+	primary := &testContext{name: "core0"}
+
+	// the first caller is recorded as core 0
+	if ret := call(t, d, primary, PSCI_CPU_ON, 1, testStart, 0); ret != PSCI_SUCCESS {
+		t.Fatalf("cpu on = %d", ret)
+	}
+
+	if p.Primary != primary {
+		t.Fatal("first caller not recorded as primary")
+	}
+
+	if ret := call(t, d, primary, PSCI_CPU_OFF); ret != PSCI_SUCCESS || !primary.stopped {
+		t.Fatalf("cpu off = %d, stopped:%v", ret, primary.stopped)
+	}
+
+	// a secondary calling after core 0 is off must retain its core
+	if ret := call(t, d, secondary, PSCI_AFFINITY_INFO, 0, 0); ret != PSCI_AFFINITY_OFF {
+		t.Errorf("core 0 affinity = %d, want off", ret)
+	}
+
+	if ret := call(t, d, secondary, PSCI_AFFINITY_INFO, 1, 0); ret != PSCI_AFFINITY_ON {
+		t.Errorf("core 1 affinity = %d, want on", ret)
+	}
+
+	if ret := call(t, d, secondary, PSCI_CPU_ON, 1, testStart, 0); ret != PSCI_ALREADY_ON {
+		t.Errorf("core 1 cpu on = %d, want already on", ret)
+	}
+
+	// a stopped core 0 can no longer power itself off
+	if ret := call(t, d, primary, PSCI_CPU_OFF); ret != PSCI_DENIED {
+		t.Errorf("stopped core cpu off = %d, want denied", ret)
+	}
+
+	// core 0 restarted by a secondary becomes the primary
+	if ret := call(t, d, secondary, PSCI_CPU_ON, 0, testStart, 0); ret != PSCI_SUCCESS {
+		t.Fatalf("core 0 cpu on = %d", ret)
+	}
+
+	if p.Primary == primary || p.Primary == secondary {
+		t.Error("restarted core 0 not recorded as primary")
+	}
+
+	if ret := call(t, d, secondary, PSCI_CPU_OFF); ret != PSCI_SUCCESS || !secondary.stopped {
+		t.Errorf("secondary cpu off = %d, stopped:%v", ret, secondary.stopped)
+	}
+
+	if ret := call(t, d, p.Primary, PSCI_AFFINITY_INFO, 1, 0); ret != PSCI_AFFINITY_OFF {
+		t.Errorf("core 1 affinity = %d, want off", ret)
+	}
+}
@@ -0,0 +1,419 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package sv39 implements encoding of RISC-V Sv39 page tables
+// (4.4 Sv39: Page-Based 39-bit Virtual-Memory System
+// RISC-V Privileged Architectures V20211203).
+//
+// The package is architecture independent, page table pages are obtained
+// through the Memory interface, it is therefore used by monitor.PageTable on
+// hardware as well as on the host for testing.
+package sv39
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// Sv39 parameters
+const (
+	Levels       = 3
+	VPNBits      = 9
+	VABits       = 39
+	PPNBits      = 44
+	Entries      = 1 << VPNBits
+	PageShift    = 12
+	PageSize     = 1 << PageShift
+	MegaPageSize = 1 << (PageShift + VPNBits)
+)
+
+// Page Table Entry fields
+const (
+	PTE_V   = 1 << 0
+	PTE_R   = 1 << 1
+	PTE_W   = 1 << 2
+	PTE_X   = 1 << 3
+	PTE_U   = 1 << 4
+	PTE_G   = 1 << 5
+	PTE_A   = 1 << 6
+	PTE_D   = 1 << 7
+	PTE_PPN = 10
+)
+
+// satp fields
+const (
+	SATP_MODE_SV39 = 8 << 60
+	SATP_ASID      = 44
+	MaxASID        = 1<<16 - 1
+)
+
+// Mapping attributes
+const (
+	// Read grants read access.
+	Read = 1 << iota
+	// Write grants write access, it implies read access.
+	Write
+	// Execute grants instruction fetches.
+	Execute
+	// User flags the mapping as accessible only in User mode.
+	User
+)
+
+// Memory represents the memory holding page table pages.
+type Memory interface {
+	// Alloc allocates a zeroed, page aligned, page table page.
+	Alloc() (addr uint64, buf []byte)
+	// Free returns the number of page table pages which can be allocated.
+	Free() int
+	// Release frees a page table page.
+	Release(addr uint64)
+}
+
+// Table represents an Sv39 page table.
+type Table struct {
+	// Root is the root page table address
+	Root uint64
+
+	mem Memory
+	// page table pages, indexed by address
+	pages map[uint64][]byte
+}
+
+// entry represents a written page table entry.
+type entry struct {
+	buf []byte
+	i   int
+}
+
+// New allocates an Sv39 root page table within the argument memory.
+func New(mem Memory) (t *Table, err error) {
+	if mem == nil {
+		return nil, errors.New("invalid page table memory")
+	}
+
+	if mem.Free() < 1 {
+		return nil, errors.New("insufficient page table memory")
+	}
+
+	t = &Table{
+		mem:   mem,
+		pages: make(map[uint64][]byte),
+	}
+
+	t.Root, _ = t.alloc()
+
+	return
+}
+
+// SATP returns the satp register value which activates the page table for
+// the argument ASID.
+func (t *Table) SATP(asid int) uint64 {
+	return SATP_MODE_SV39 | uint64(asid)<<SATP_ASID | t.Root>>PageShift
+}
+
+// Release frees all page table pages, the table must not be used after it is
+// released.
+func (t *Table) Release() {
+	for addr := range t.pages {
+		t.mem.Release(addr)
+	}
+
+	t.pages = nil
+	t.Root = 0
+}
+
+// Pages returns the number of allocated page table pages.
+func (t *Table) Pages() int {
+	return len(t.pages)
+}
+
+func (t *Table) alloc() (addr uint64, buf []byte) {
+	addr, buf = t.mem.Alloc()
+	t.pages[addr] = buf
+	return
+}
+
+func (t *Table) release(addr uint64) {
+	delete(t.pages, addr)
+	t.mem.Release(addr)
+}
+
+func read(buf []byte, i int) uint64 {
+	return binary.LittleEndian.Uint64(buf[i*8:])
+}
+
+func write(buf []byte, i int, pte uint64) {
+	binary.LittleEndian.PutUint64(buf[i*8:], pte)
+}
+
+func vpn(va uint64, level int) int {
+	return int(va>>(PageShift+level*VPNBits)) & (Entries - 1)
+}
+
+func leaf(pte uint64) bool {
+	return pte&(PTE_R|PTE_W|PTE_X) != 0
+}
+
+func ppn(pte uint64) uint64 {
+	return ((pte >> PTE_PPN) & (1<<PPNBits - 1)) << PageShift
+}
+
+// Flags returns the leaf entry flags for the argument mapping attributes.
+func Flags(attr int) (pte uint64) {
+	pte = PTE_V | PTE_A
+
+	if attr&User != 0 {
+		pte |= PTE_U
+	}
+
+	if attr&(Read|Write) != 0 {
+		pte |= PTE_R
+	}
+
+	if attr&Write != 0 {
+		pte |= PTE_W | PTE_D
+	}
+
+	if attr&Execute != 0 {
+		pte |= PTE_X
+	}
+
+	return
+}
+
+// level returns the leaf level for a mapping step.
+func level(va uint64, pa uint64, remaining uint64) int {
+	if va%MegaPageSize == 0 && pa%MegaPageSize == 0 && remaining >= MegaPageSize {
+		return 1
+	}
+
+	return 0
+}
+
+// needed returns the number of page table pages to be allocated to map the
+// argument range.
+func (t *Table) needed(va uint64, pa uint64, size uint64) (n int) {
+	seen := make(map[[2]uint64]bool)
+
+	for off := uint64(0); off < size; {
+		v := va + off
+		lvl := level(v, pa+off, size-off)
+		buf := t.pages[t.Root]
+
+		for l := Levels - 1; l > lvl; l-- {
+			if buf != nil {
+				pte := read(buf, vpn(v, l))
+
+				if pte&PTE_V != 0 {
+					if leaf(pte) {
+						break
+					}
+
+					buf = t.pages[ppn(pte)]
+					continue
+				}
+
+				buf = nil
+			}
+
+			key := [2]uint64{uint64(l), v >> (PageShift + l*VPNBits)}
+
+			if !seen[key] {
+				seen[key] = true
+				n += 1
+			}
+		}
+
+		off += PageSize << (lvl * VPNBits)
+	}
+
+	return
+}
+
+// walk returns the page table page and index holding the leaf entry for the
+// argument virtual address at the requested level, intermediate page tables
+// are allocated as needed when written is not nil, in which case their
+// entries are appended to it.
+func (t *Table) walk(va uint64, level int, written *[]entry) (buf []byte, i int, err error) {
+	buf = t.pages[t.Root]
+
+	for l := Levels - 1; l > level; l-- {
+		i = vpn(va, l)
+		pte := read(buf, i)
+
+		switch {
+		case pte&PTE_V == 0:
+			if written == nil {
+				return nil, 0, fmt.Errorf("%#x not mapped", va)
+			}
+
+			addr, next := t.alloc()
+			write(buf, i, (addr>>PageShift)<<PTE_PPN|PTE_V)
+			*written = append(*written, entry{buf, i})
+			buf = next
+		case leaf(pte):
+			return nil, 0, fmt.Errorf("%#x overlaps level %d leaf", va, l)
+		default:
+			if buf = t.pages[ppn(pte)]; buf == nil {
+				return nil, 0, fmt.Errorf("invalid page table at %#x", ppn(pte))
+			}
+		}
+	}
+
+	return buf, vpn(va, level), nil
+}
+
+// undo reverts the argument written entries, releasing the intermediate page
+// tables allocated along with them.
+func (t *Table) undo(written []entry) {
+	for i := len(written) - 1; i >= 0; i-- {
+		e := written[i]
+		pte := read(e.buf, e.i)
+
+		write(e.buf, e.i, 0)
+
+		if !leaf(pte) {
+			t.release(ppn(pte))
+		}
+	}
+}
+
+// Map maps size bytes of physical memory, starting at pa, to virtual address
+// va with the argument attributes (see Read, Write, Execute, User).
+//
+// Addresses and sizes must be aligned to 4KB, 2MB aligned ranges are mapped
+// with megapages while the remainder is mapped with 4KB pages.
+//
+// The required page table pages are checked against the available memory
+// before any change, on failure all changes are reverted.
+func (t *Table) Map(va uint64, pa uint64, size uint64, attr int) (err error) {
+	if va%PageSize != 0 || pa%PageSize != 0 || size%PageSize != 0 || size == 0 {
+		return errors.New("invalid alignment")
+	}
+
+	if va+size < va || va+size > 1<<(VABits-1) || pa+size < pa || pa+size > 1<<(PPNBits+PageShift) {
+		return errors.New("invalid range")
+	}
+
+	if attr&(Read|Write|Execute) == 0 {
+		return errors.New("invalid permissions")
+	}
+
+	if n := t.needed(va, pa, size); n > t.mem.Free() {
+		return fmt.Errorf("insufficient page table memory (%d pages required)", n)
+	}
+
+	var written []entry
+
+	flags := Flags(attr)
+
+	defer func() {
+		if err != nil {
+			t.undo(written)
+		}
+	}()
+
+	for off := uint64(0); off < size; {
+		v := va + off
+		p := pa + off
+		lvl := level(v, p, size-off)
+
+		buf, i, err := t.walk(v, lvl, &written)
+
+		if err != nil {
+			return err
+		}
+
+		if read(buf, i)&PTE_V != 0 {
+			return fmt.Errorf("%#x already mapped", v)
+		}
+
+		write(buf, i, (p>>PageShift)<<PTE_PPN|flags)
+		written = append(written, entry{buf, i})
+
+		off += PageSize << (lvl * VPNBits)
+	}
+
+	return
+}
+
+// Unmap removes size bytes of virtual memory, starting at va, from the page
+// table. Addresses and sizes must match the granularity of their existing
+// mappings.
+func (t *Table) Unmap(va uint64, size uint64) (err error) {
+	if va%PageSize != 0 || size%PageSize != 0 || size == 0 {
+		return errors.New("invalid alignment")
+	}
+
+	for off := uint64(0); off < size; {
+		v := va + off
+		lvl := 0
+
+		// detect megapages
+		if buf, i, err := t.walk(v, 1, nil); err == nil && leaf(read(buf, i)) {
+			if v%MegaPageSize != 0 || size-off < MegaPageSize {
+				return fmt.Errorf("%#x partially unmaps megapage", v)
+			}
+
+			lvl = 1
+		}
+
+		buf, i, err := t.walk(v, lvl, nil)
+
+		if err != nil {
+			return err
+		}
+
+		write(buf, i, 0)
+		off += PageSize << (lvl * VPNBits)
+	}
+
+	return
+}
+
+// Walk translates a virtual address by walking the page table, it returns the
+// corresponding physical address and mapping attributes.
+func (t *Table) Walk(va uint64) (pa uint64, attr int, err error) {
+	buf := t.pages[t.Root]
+
+	for l := Levels - 1; l >= 0; l-- {
+		pte := read(buf, vpn(va, l))
+
+		if pte&PTE_V == 0 {
+			return 0, 0, fmt.Errorf("%#x not mapped", va)
+		}
+
+		if !leaf(pte) {
+			if buf = t.pages[ppn(pte)]; buf == nil {
+				return 0, 0, fmt.Errorf("invalid page table at %#x", ppn(pte))
+			}
+
+			continue
+		}
+
+		pa = ppn(pte) + va&(PageSize<<(l*VPNBits)-1)
+
+		if pte&PTE_R != 0 {
+			attr |= Read
+		}
+
+		if pte&PTE_W != 0 {
+			attr |= Write
+		}
+
+		if pte&PTE_X != 0 {
+			attr |= Execute
+		}
+
+		if pte&PTE_U != 0 {
+			attr |= User
+		}
+
+		return
+	}
+
+	return 0, 0, fmt.Errorf("%#x not mapped", va)
+}
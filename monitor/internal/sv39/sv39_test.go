@@ -0,0 +1,344 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sv39
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+const testBase = 0x80000000
+
+// testMemory is a host page table memory with a fixed number of pages.
+type testMemory struct {
+	used  map[uint64][]byte
+	pages int
+}
+
+func newTestMemory(pages int) *testMemory {
+	return &testMemory{
+		used:  make(map[uint64][]byte),
+		pages: pages,
+	}
+}
+
+func (m *testMemory) Alloc() (addr uint64, buf []byte) {
+	for i := 0; i < m.pages; i++ {
+		addr = testBase + uint64(i*PageSize)
+
+		if _, ok := m.used[addr]; !ok {
+			buf = make([]byte, PageSize)
+			m.used[addr] = buf
+			return
+		}
+	}
+
+	panic("out of memory")
+}
+
+func (m *testMemory) Free() int {
+	return m.pages - len(m.used)
+}
+
+func (m *testMemory) Release(addr uint64) {
+	delete(m.used, addr)
+}
+
+// hwWalk translates a virtual address by decoding the raw page table pages,
+// as a hardware page walker would, it returns the leaf entry and level.
+func hwWalk(t *testing.T, m *testMemory, satp uint64, va uint64) (pte uint64, level int) {
+	t.Helper()
+
+	if satp>>60 != 8 {
+		t.Fatalf("invalid satp mode %#x", satp)
+	}
+
+	addr := (satp & (1<<PPNBits - 1)) << PageShift
+
+	for level = Levels - 1; level >= 0; level-- {
+		buf, ok := m.used[addr]
+
+		if !ok {
+			t.Fatalf("walk of %#x reached unallocated table %#x", va, addr)
+		}
+
+		i := (va >> (PageShift + level*VPNBits)) & (Entries - 1)
+		pte = binary.LittleEndian.Uint64(buf[i*8:])
+
+		if pte&PTE_V == 0 {
+			return 0, level
+		}
+
+		if pte&(PTE_R|PTE_W|PTE_X) != 0 {
+			return
+		}
+
+		addr = (pte >> PTE_PPN) << PageShift
+	}
+
+	t.Fatalf("walk of %#x exceeded levels", va)
+
+	return
+}
+
+func TestMap(t *testing.T) {
+	tests := []struct {
+		name  string
+		va    uint64
+		pa    uint64
+		size  uint64
+		attr  int
+		level int
+		flags uint64
+		pages int
+	}{
+		{
+			name:  "4KB read-only",
+			va:    0x1000,
+			pa:    0x90001000,
+			size:  PageSize,
+			attr:  Read,
+			level: 0,
+			flags: PTE_V | PTE_A | PTE_R,
+			pages: 3,
+		},
+		{
+			name:  "4KB user text",
+			va:    0x40000000,
+			pa:    0x90000000,
+			size:  2 * PageSize,
+			attr:  Read | Execute | User,
+			level: 0,
+			flags: PTE_V | PTE_A | PTE_R | PTE_X | PTE_U,
+			pages: 3,
+		},
+		{
+			name:  "megapage write",
+			va:    0x80000000,
+			pa:    0x80000000,
+			size:  2 * MegaPageSize,
+			attr:  Write,
+			level: 1,
+			flags: PTE_V | PTE_A | PTE_R | PTE_W | PTE_D,
+			pages: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newTestMemory(16)
+			pt, err := New(m)
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = pt.Map(tt.va, tt.pa, tt.size, tt.attr); err != nil {
+				t.Fatal(err)
+			}
+
+			if got := pt.Pages(); got != tt.pages {
+				t.Errorf("pages = %d, want %d", got, tt.pages)
+			}
+
+			satp := pt.SATP(7)
+
+			if asid := (satp >> SATP_ASID) & MaxASID; asid != 7 {
+				t.Errorf("satp ASID = %d, want 7", asid)
+			}
+
+			for off := uint64(0); off < tt.size; off += PageSize {
+				va := tt.va + off
+				pte, level := hwWalk(t, m, satp, va)
+
+				if level != tt.level {
+					t.Fatalf("%#x: level = %d, want %d", va, level, tt.level)
+				}
+
+				if flags := pte & (1<<PTE_PPN - 1); flags != tt.flags {
+					t.Errorf("%#x: flags = %#x, want %#x", va, flags, tt.flags)
+				}
+
+				pa := (pte>>PTE_PPN)<<PageShift + va&(PageSize<<(level*VPNBits)-1)
+
+				if pa != tt.pa+off {
+					t.Errorf("%#x: pa = %#x, want %#x", va, pa, tt.pa+off)
+				}
+
+				if wpa, attr, err := pt.Walk(va); err != nil || wpa != pa || attr&tt.attr != tt.attr {
+					t.Errorf("%#x: Walk = %#x, %#x, %v", va, wpa, attr, err)
+				}
+			}
+
+			if _, _, err := pt.Walk(tt.va + tt.size); err == nil {
+				t.Errorf("%#x: unexpected mapping", tt.va+tt.size)
+			}
+		})
+	}
+}
+
+func TestMapMixed(t *testing.T) {
+	m := newTestMemory(16)
+	pt, _ := New(m)
+
+	// 4KB head, one megapage, 4KB tail
+	va := uint64(MegaPageSize - PageSize)
+	size := uint64(MegaPageSize + 2*PageSize)
+
+	if err := pt.Map(va, va, size, Read|Write); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range []struct {
+		va    uint64
+		level int
+	}{
+		{va, 0},
+		{MegaPageSize, 1},
+		{2*MegaPageSize - PageSize, 1},
+		{2 * MegaPageSize, 0},
+	} {
+		if _, level := hwWalk(t, m, pt.SATP(0), c.va); level != c.level {
+			t.Errorf("%#x: level = %d, want %d", c.va, level, c.level)
+		}
+	}
+}
+
+func TestMapErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		va   uint64
+		pa   uint64
+		size uint64
+		attr int
+	}{
+		{"unaligned va", 0x1001, 0, PageSize, Read},
+		{"unaligned size", 0x1000, 0, 1, Read},
+		{"zero size", 0x1000, 0, 0, Read},
+		{"no permissions", 0x1000, 0, PageSize, 0},
+		{"va out of range", 1<<(VABits-1) - PageSize, 0, 2 * PageSize, Read},
+		{"va overflow", ^uint64(PageSize - 1), 0, 2 * PageSize, Read},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pt, _ := New(newTestMemory(4))
+
+			if err := pt.Map(tt.va, tt.pa, tt.size, tt.attr); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestMapInsufficientMemory(t *testing.T) {
+	m := newTestMemory(3)
+	pt, _ := New(m)
+
+	// two distinct 1GB ranges require two level 1 and two level 0 tables
+	if err := pt.Map(0, 0, PageSize, Read); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pt.Map(1<<30, 0, PageSize, Read); err == nil {
+		t.Fatal("expected insufficient memory error")
+	}
+
+	if got := pt.Pages(); got != 3 {
+		t.Errorf("pages = %d, want 3", got)
+	}
+
+	if _, _, err := pt.Walk(0); err != nil {
+		t.Errorf("existing mapping lost: %v", err)
+	}
+}
+
+func TestMapRollback(t *testing.T) {
+	m := newTestMemory(16)
+	pt, _ := New(m)
+
+	if err := pt.Map(0x203000, 0x203000, PageSize, Read); err != nil {
+		t.Fatal(err)
+	}
+
+	pages := pt.Pages()
+	free := m.Free()
+
+	// a range spanning two 1GB regions allocates three tables
+	va := uint64(1<<30) - 0x1000
+	size := uint64(0x3000)
+
+	if err := pt.Map(va, va, size, Read); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed mapping within existing tables must revert its entries
+	if err := pt.Map(0x200000, 0x200000, 0x8000, Read); err == nil {
+		t.Fatal("expected overlap error")
+	}
+
+	for _, va := range []uint64{0x200000, 0x201000, 0x202000} {
+		if _, _, err := pt.Walk(va); err == nil {
+			t.Errorf("%#x: mapping not reverted", va)
+		}
+	}
+
+	if err := pt.Unmap(va, size); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed mapping spanning new tables must release them
+	if err := pt.Map(0x1ff000, 0x1ff000, 0x5000, Read); err == nil {
+		t.Fatal("expected overlap error")
+	}
+
+	if got := pt.Pages(); got != pages+3 {
+		t.Errorf("pages = %d, want %d", got, pages+3)
+	}
+
+	if got := m.Free(); got != free-3 {
+		t.Errorf("free = %d, want %d", got, free-3)
+	}
+}
+
+func TestUnmap(t *testing.T) {
+	pt, _ := New(newTestMemory(16))
+
+	if err := pt.Map(0, 0, 2*MegaPageSize, Read); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pt.Unmap(PageSize, PageSize); err == nil {
+		t.Error("expected partial megapage unmap error")
+	}
+
+	if err := pt.Unmap(0, MegaPageSize); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := pt.Walk(0); err == nil {
+		t.Error("unmapped address still mapped")
+	}
+
+	if _, _, err := pt.Walk(MegaPageSize); err != nil {
+		t.Errorf("remaining megapage lost: %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	m := newTestMemory(16)
+	pt, _ := New(m)
+
+	if err := pt.Map(0, 0, 4*PageSize, Read); err != nil {
+		t.Fatal(err)
+	}
+
+	pt.Release()
+
+	if got := m.Free(); got != 16 {
+		t.Errorf("free = %d, want 16", got)
+	}
+}
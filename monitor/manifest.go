@@ -0,0 +1,112 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/GoTEE/manifest"
+	"github.com/usbarmory/GoTEE/syscall"
+)
+
+// manifestSyscalls maps manifest system call names to their numbers.
+var manifestSyscalls = map[string][]uint{
+	"exit":      {syscall.SYS_EXIT},
+	"write":     {syscall.SYS_WRITE},
+	"nanotime":  {syscall.SYS_NANOTIME},
+	"getrandom": {syscall.SYS_GETRANDOM},
+	"rpc":       {syscall.SYS_RPC_REQ, syscall.SYS_RPC_RES},
+	"serve":     {syscall.SYS_RPC_SRV_REQ, syscall.SYS_RPC_SRV_RES},
+}
+
+// filter returns a handler which only passes the allowed system calls, as
+// well as any other exception or interrupt, to the argument handler.
+func filter(h func(ctx *ExecCtx) error, allowed map[uint]bool) func(ctx *ExecCtx) error {
+	return func(ctx *ExecCtx) (err error) {
+		if ctx.systemCall() && !allowed[ctx.A0()] {
+			return fmt.Errorf("syscall %d not allowed", ctx.A0())
+		}
+
+		return h(ctx)
+	}
+}
+
+// LoadManifest returns an execution context initialized according to the
+// argument applet manifest, the manifest entry point, if set, overrides the
+// argument one.
+//
+// The execution context Memory is allocated from the manifest memory region,
+// which must fit the platform address range, the executable image is expected
+// to be copied there by the caller. Secure execution contexts Handler only
+// passes the manifest allowed system calls to SecureHandler.
+//
+// The manifest RPC services and restart policy are applied with
+// Bridge.AddManifest() and manifest.Policy().
+func LoadManifest(m *manifest.Manifest, entry uint) (ctx *ExecCtx, err error) {
+	if err = m.Validate(); err != nil {
+		return
+	}
+
+	// the region end also bounds the entry point, validated within it
+	if end := uint64(m.Memory.Start + m.Memory.Size - 1); end > uint64(^uint(0)) || uint64(m.Memory.Size) > math.MaxInt {
+		return nil, errors.New("memory region exceeds platform address range")
+	}
+
+	if m.Entry != 0 {
+		entry = uint(m.Entry)
+	}
+
+	mem, err := dma.NewRegion(uint(m.Memory.Start), int(m.Memory.Size), false)
+
+	if err != nil {
+		return
+	}
+
+	if ctx, err = loadManifest(entry, mem, m); err != nil {
+		return
+	}
+
+	if m.Secure && len(m.Syscalls) > 0 {
+		allowed := make(map[uint]bool)
+
+		for _, s := range m.Syscalls {
+			for _, num := range manifestSyscalls[s] {
+				allowed[num] = true
+			}
+		}
+
+		ctx.Handler = filter(ctx.Handler, allowed)
+	}
+
+	ctx.Deterministic = m.Deterministic
+
+	return
+}
+
+// AddManifest applies the argument applet manifest RPC configuration to the
+// bridge, services of secure execution contexts are added (see Add()) while
+// patterns of non-secure ones are allowed (see Allow()).
+func (b *Bridge) AddManifest(ctx *ExecCtx, m *manifest.Manifest) (err error) {
+	if err = m.Validate(); err != nil {
+		return
+	}
+
+	for _, service := range m.Services {
+		if err = b.Add(service, ctx); err != nil {
+			return
+		}
+	}
+
+	if len(m.Allow) > 0 {
+		return b.Allow(ctx, m.Allow...)
+	}
+
+	return
+}
@@ -0,0 +1,24 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"errors"
+
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/GoTEE/manifest"
+)
+
+// loadManifest initializes an execution context for a manifest, the
+// privilege level is implied by the security state.
+func loadManifest(entry uint, mem *dma.Region, m *manifest.Manifest) (*ExecCtx, error) {
+	if m.Mode != "" {
+		return nil, errors.New("privilege level selection is not supported")
+	}
+
+	return Load(entry, mem, m.Secure)
+}
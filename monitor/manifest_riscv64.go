@@ -0,0 +1,24 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/GoTEE/manifest"
+)
+
+// loadManifest initializes an execution context for a manifest, at
+// Supervisor privilege level unless otherwise specified.
+func loadManifest(entry uint, mem *dma.Region, m *manifest.Manifest) (*ExecCtx, error) {
+	mode := Supervisor
+
+	if m.Mode == manifest.ModeUser {
+		mode = User
+	}
+
+	return LoadMode(entry, mem, mode, m.Secure)
+}
@@ -0,0 +1,30 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"unsafe"
+)
+
+// PageTable mapping attributes (see PageTable.Map).
+const (
+	// MapRead grants read access to the execution context.
+	MapRead = 1 << iota
+	// MapWrite grants write access to the execution context.
+	MapWrite
+	// MapExecute grants instruction fetches to the execution context.
+	MapExecute
+	// MapDevice flags the mapping as device (non-cacheable) memory.
+	MapDevice
+)
+
+// mem returns a slice for direct access to physical memory.
+func mem(addr uint, size int) []byte {
+	var ptr unsafe.Pointer
+
+	ptr = unsafe.Add(ptr, addr)
+	return unsafe.Slice((*byte)(ptr), size)
+}
@@ -0,0 +1,506 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/usbarmory/tamago/dma"
+	"github.com/usbarmory/tamago/soc/nxp/imx6ul"
+)
+
+// Short-descriptor translation table format
+// (B3.5, ARM Architecture Reference Manual ARMv7-A and ARMv7-R edition).
+const (
+	l1Entries = 4096
+	l1Size    = l1Entries * 4
+	l2Entries = 256
+	l2Size    = l2Entries * 4
+
+	sectionShift = 20
+	sectionSize  = 1 << sectionShift
+	pageShift    = 12
+	pageSize     = 1 << pageShift
+
+	tteTypeMask  = 0b11
+	ttePageTable = 0b01
+	tteSection   = 0b10
+	ttePage      = 0b10
+
+	// section descriptor fields
+	sectionB      = 1 << 2
+	sectionC      = 1 << 3
+	sectionXN     = 1 << 4
+	sectionDomain = 5
+	sectionAP     = 10
+	sectionAP2    = 1 << 15
+	sectionNG     = 1 << 17
+	sectionNS     = 1 << 19
+
+	// page table descriptor fields
+	tableNS     = 1 << 3
+	tableDomain = 5
+
+	// small page descriptor fields
+	pageXN = 1 << 0
+	pageB  = 1 << 2
+	pageC  = 1 << 3
+	pageAP = 4
+	pageNG = 1 << 11
+
+	// Access Permissions (AP[1:0])
+	apPrivileged = 0b01
+	apReadOnly   = 0b10
+	apReadWrite  = 0b11
+
+	// ASID 0 is reserved to the Trusted OS
+	maxASID = 255
+)
+
+// defined in mmu_arm.s
+func read_ttbr0() uint32
+func read_contextidr() uint32
+func set_ttbr0(ttbr0 uint32, contextidr uint32)
+func flush_tlb_asid(asid uint32)
+
+// Trusted OS first-level entries flagged as non-global by PageTable.Map(),
+// with the number of page tables referencing them.
+var nonGlobal = struct {
+	sync.Mutex
+	refs map[int]int
+}{
+	refs: make(map[int]int),
+}
+
+// PageTable represents a first-level translation table, tagged with an
+// Address Space Identifier (ASID), which replaces the Trusted OS one while a
+// secure execution context is scheduled.
+//
+// The table is initialized with all Trusted OS mappings, restricted to
+// privileged access, while the execution context mappings (see Map()) are
+// flagged as non-global to be only valid for the table ASID. This allows
+// distinct execution contexts to map the same virtual addresses.
+type PageTable struct {
+	// ASID is the Address Space Identifier (1-255)
+	ASID int
+	// Domain is the domain ID (0-15) assigned to all mappings
+	Domain uint32
+
+	// first-level table
+	l1  uint
+	buf []byte
+	// second-level tables, indexed by first-level entry
+	l2 map[int][]byte
+
+	// Trusted OS first-level entries flagged as non-global
+	ng map[int]bool
+
+	// Trusted OS TTBR0 and CONTEXTIDR while the table is active
+	ttbr0      uint32
+	contextidr uint32
+}
+
+func readEntry(buf []byte, i int) uint32 {
+	return binary.LittleEndian.Uint32(buf[i*4:])
+}
+
+func writeEntry(buf []byte, i int, tte uint32) {
+	binary.LittleEndian.PutUint32(buf[i*4:], tte)
+}
+
+// reserve allocates an aligned translation table on the global DMA region,
+// after verifying that it can be reserved as dma.Reserve() panics otherwise.
+func reserve(size int) (addr uint, buf []byte, err error) {
+	for start, n := range dma.Default().FreeBlocks() {
+		// pad to required alignment
+		pad := -start & uint(size-1)
+
+		if n >= uint(size)+pad {
+			addr, buf = dma.Reserve(size, size)
+			return
+		}
+	}
+
+	return 0, nil, errors.New("could not allocate translation table")
+}
+
+// trustedOS returns the Trusted OS first-level translation table.
+func trustedOS() []byte {
+	return mem(uint(read_ttbr0()&^(l1Size-1)), l1Size)
+}
+
+// NewPageTable allocates, on the global DMA region, a first-level translation
+// table for the argument ASID and domain.
+//
+// The table is initialized as a copy of the current Trusted OS translation
+// table with all section mappings restricted to privileged access.
+func NewPageTable(asid int, domain uint32) (pt *PageTable, err error) {
+	if asid <= 0 || asid > maxASID {
+		return nil, fmt.Errorf("invalid ASID %d", asid)
+	}
+
+	if domain > 15 {
+		return nil, fmt.Errorf("invalid domain %d", domain)
+	}
+
+	pt = &PageTable{
+		ASID:   asid,
+		Domain: domain,
+		l2:     make(map[int][]byte),
+		ng:     make(map[int]bool),
+	}
+
+	if pt.l1, pt.buf, err = reserve(l1Size); err != nil {
+		return nil, err
+	}
+
+	os := trustedOS()
+
+	for i := 0; i < l1Entries; i++ {
+		tte := readEntry(os, i)
+
+		if tte&tteTypeMask == tteSection {
+			tte &^= sectionAP2 | (0b11 << sectionAP)
+			tte |= apPrivileged << sectionAP
+		}
+
+		writeEntry(pt.buf, i, tte)
+	}
+
+	pt.flush()
+
+	return
+}
+
+// Release frees the translation table memory, the table must not be used
+// after it is released.
+//
+// The Trusted OS translation table entries flagged as non-global by Map() are
+// restored once no other PageTable references them.
+func (pt *PageTable) Release() {
+	pt.restore()
+
+	for _, buf := range pt.l2 {
+		dma.Release(uint(uintptr(unsafe.Pointer(&buf[0]))))
+	}
+
+	dma.Release(pt.l1)
+
+	pt.l2 = nil
+	pt.ng = nil
+	pt.buf = nil
+	pt.l1 = 0
+}
+
+// isolate flags the Trusted OS translation table entry as non-global, to
+// prevent its TLB entries from matching the execution context ASID.
+func (pt *PageTable) isolate(os []byte, i int) {
+	if pt.ng[i] {
+		return
+	}
+
+	nonGlobal.Lock()
+	defer nonGlobal.Unlock()
+
+	tte := readEntry(os, i)
+
+	// leave entries which are already non-global untracked
+	if nonGlobal.refs[i] == 0 && tte&sectionNG != 0 {
+		return
+	}
+
+	writeEntry(os, i, tte|sectionNG)
+
+	nonGlobal.refs[i] += 1
+	pt.ng[i] = true
+}
+
+// restore reverts the non-global flag on the Trusted OS translation table
+// entries isolated by the PageTable and no longer referenced by others.
+func (pt *PageTable) restore() {
+	if len(pt.ng) == 0 {
+		return
+	}
+
+	nonGlobal.Lock()
+	defer nonGlobal.Unlock()
+
+	os := trustedOS()
+
+	for i := range pt.ng {
+		if nonGlobal.refs[i] -= 1; nonGlobal.refs[i] > 0 {
+			continue
+		}
+
+		delete(nonGlobal.refs, i)
+		writeEntry(os, i, readEntry(os, i)&^sectionNG)
+	}
+
+	imx6ul.ARM.FlushDataCache()
+	imx6ul.ARM.FlushTLBs()
+}
+
+func (pt *PageTable) flush() {
+	imx6ul.ARM.FlushDataCache()
+	flush_tlb_asid(uint32(pt.ASID))
+}
+
+func (pt *PageTable) sectionFlags(flags int) (tte uint32) {
+	tte = tteSection | sectionNG | pt.Domain<<sectionDomain
+
+	switch {
+	case flags&MapWrite != 0:
+		tte |= apReadWrite << sectionAP
+	case flags&MapRead != 0:
+		tte |= apReadOnly << sectionAP
+	default:
+		tte |= apPrivileged << sectionAP
+	}
+
+	if flags&MapExecute == 0 {
+		tte |= sectionXN
+	}
+
+	if flags&MapDevice == 0 {
+		tte |= sectionC | sectionB
+	}
+
+	return
+}
+
+func (pt *PageTable) pageFlags(flags int) (tte uint32) {
+	tte = ttePage | pageNG
+
+	switch {
+	case flags&MapWrite != 0:
+		tte |= apReadWrite << pageAP
+	case flags&MapRead != 0:
+		tte |= apReadOnly << pageAP
+	default:
+		tte |= apPrivileged << pageAP
+	}
+
+	if flags&MapExecute == 0 {
+		tte |= pageXN
+	}
+
+	if flags&MapDevice == 0 {
+		tte |= pageC | pageB
+	}
+
+	return
+}
+
+// table returns the second-level table for the argument first-level entry,
+// the table is allocated and populated with equivalent small page entries if
+// the first-level entry does not already point to one.
+func (pt *PageTable) table(i int) (buf []byte, err error) {
+	if buf, ok := pt.l2[i]; ok {
+		return buf, nil
+	}
+
+	tte := readEntry(pt.buf, i)
+
+	addr, buf, err := reserve(l2Size)
+
+	if err != nil {
+		return
+	}
+
+	desc := uint32(addr) | ttePageTable | pt.Domain<<tableDomain
+
+	switch tte & tteTypeMask {
+	case ttePageTable:
+		// copy Trusted OS second-level table
+		copy(buf, mem(uint(tte&^(l2Size-1)), l2Size))
+		desc = uint32(addr) | (tte & (l2Size - 1))
+	case tteSection:
+		base := tte &^ (sectionSize - 1)
+		desc = uint32(addr) | ttePageTable | tte&(0xf<<sectionDomain)
+
+		if tte&sectionNS != 0 {
+			desc |= tableNS
+		}
+
+		// translate section attributes to small page ones
+		attr := uint32(ttePage)
+		attr |= tte & (sectionB | sectionC)
+		attr |= ((tte >> sectionAP) & 0b11) << pageAP
+		attr |= ((tte >> 12) & 0b111) << 6 // TEX
+		attr |= ((tte >> 15) & 0b111) << 9 // AP2, S, nG
+
+		if tte&sectionXN != 0 {
+			attr |= pageXN
+		}
+
+		for j := 0; j < l2Entries; j++ {
+			writeEntry(buf, j, base+uint32(j<<pageShift)|attr)
+		}
+	default:
+		clear(buf)
+	}
+
+	writeEntry(pt.buf, i, desc)
+	pt.l2[i] = buf
+
+	return
+}
+
+// Map maps size bytes of physical memory, starting at pa, to virtual address
+// va with the argument attribute flags (see MapRead, MapWrite, MapExecute,
+// MapDevice).
+//
+// Addresses and sizes must be aligned to 4KB, 1MB aligned ranges are mapped
+// with first-level section entries while the remainder is mapped with
+// second-level small page entries.
+//
+// The Trusted OS translation table entries for the mapped virtual addresses
+// are flagged as non-global, to prevent their TLB entries from matching the
+// execution context ASID, therefore va must not overlap with Trusted OS
+// second-level tables.
+func (pt *PageTable) Map(va uint32, pa uint32, size uint32, flags int) (err error) {
+	if va%pageSize != 0 || pa%pageSize != 0 || size%pageSize != 0 || size == 0 {
+		return errors.New("invalid alignment")
+	}
+
+	if uint64(va)+uint64(size) > 1<<32 || uint64(pa)+uint64(size) > 1<<32 {
+		return errors.New("invalid range")
+	}
+
+	start := va &^ (sectionSize - 1)
+	end := va + (size - 1)
+
+	os := trustedOS()
+
+	for addr := uint64(start); addr <= uint64(end); addr += sectionSize {
+		if readEntry(os, int(addr>>sectionShift))&tteTypeMask != tteSection {
+			return fmt.Errorf("%#x overlaps Trusted OS second-level table", addr)
+		}
+	}
+
+	// isolate Trusted OS TLB entries from the execution context ASID
+	for addr := uint64(start); addr <= uint64(end); addr += sectionSize {
+		pt.isolate(os, int(addr>>sectionShift))
+	}
+
+	imx6ul.ARM.FlushDataCache()
+	imx6ul.ARM.FlushTLBs()
+
+	defer pt.flush()
+
+	for off := uint32(0); off < size; {
+		v := va + off
+		p := pa + off
+		i := int(v >> sectionShift)
+
+		if _, split := pt.l2[i]; !split && v%sectionSize == 0 && p%sectionSize == 0 && size-off >= sectionSize {
+			writeEntry(pt.buf, i, p|pt.sectionFlags(flags))
+			off += sectionSize
+			continue
+		}
+
+		buf, err := pt.table(i)
+
+		if err != nil {
+			return err
+		}
+
+		writeEntry(buf, int((v>>pageShift)&(l2Entries-1)), p|pt.pageFlags(flags))
+		off += pageSize
+	}
+
+	return
+}
+
+// Unmap removes size bytes of virtual memory, starting at va, from the
+// translation table.
+//
+// Addresses and sizes must be aligned to 4KB.
+func (pt *PageTable) Unmap(va uint32, size uint32) (err error) {
+	if va%pageSize != 0 || size%pageSize != 0 || size == 0 {
+		return errors.New("invalid alignment")
+	}
+
+	if uint64(va)+uint64(size) > 1<<32 {
+		return errors.New("invalid range")
+	}
+
+	defer pt.flush()
+
+	for off := uint32(0); off < size; {
+		v := va + off
+		i := int(v >> sectionShift)
+
+		if buf, ok := pt.l2[i]; ok {
+			writeEntry(buf, int((v>>pageShift)&(l2Entries-1)), 0)
+			off += pageSize
+			continue
+		}
+
+		if v%sectionSize == 0 && size-off >= sectionSize {
+			writeEntry(pt.buf, i, 0)
+			off += sectionSize
+			continue
+		}
+
+		buf, err := pt.table(i)
+
+		if err != nil {
+			return err
+		}
+
+		writeEntry(buf, int((v>>pageShift)&(l2Entries-1)), 0)
+		off += pageSize
+	}
+
+	return
+}
+
+// activate switches the current translation table to the PageTable one.
+func (pt *PageTable) activate() {
+	pt.ttbr0 = read_ttbr0()
+	pt.contextidr = read_contextidr()
+	set_ttbr0(uint32(pt.l1), uint32(pt.ASID))
+}
+
+// deactivate restores the Trusted OS translation table and ASID.
+func (pt *PageTable) deactivate() {
+	set_ttbr0(pt.ttbr0, pt.contextidr)
+}
+
+// InitPageTable assigns a new PageTable, for the argument ASID, to a secure
+// execution context, its Memory is mapped with a flat translation and full
+// access permissions.
+//
+// Additional regions (e.g. shared buffers, device pages) can be made
+// accessible to the execution context, and only to it, with PageTable.Map().
+func (ctx *ExecCtx) InitPageTable(asid int) (err error) {
+	if ctx.ns {
+		return errors.New("page tables are only supported on secure execution contexts")
+	}
+
+	pt, err := NewPageTable(asid, ctx.Domain)
+
+	if err != nil {
+		return
+	}
+
+	start := uint32(ctx.Memory.Start())
+	size := uint32(ctx.Memory.Size())
+
+	if err = pt.Map(start, start, size, MapRead|MapWrite|MapExecute); err != nil {
+		pt.Release()
+		return
+	}
+
+	ctx.PageTable = pt
+
+	return
+}
@@ -0,0 +1,56 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func read_ttbr0() uint32
+TEXT ·read_ttbr0(SB),NOSPLIT,$0-4
+	MRC	15, 0, R0, C2, C0, 0
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func read_contextidr() uint32
+TEXT ·read_contextidr(SB),NOSPLIT,$0-4
+	MRC	15, 0, R0, C13, C0, 1
+	MOVW	R0, ret+0(FP)
+
+	RET
+
+// func set_ttbr0(ttbr0 uint32, contextidr uint32)
+TEXT ·set_ttbr0(SB),NOSPLIT,$0-8
+	MOVW	ttbr0+0(FP), R0
+	MOVW	contextidr+4(FP), R1
+
+	// switch to reserved ASID before changing TTBR0
+	// (B3.10.4, ARM Architecture Reference Manual ARMv7-A and ARMv7-R edition)
+	MOVW	$0, R2
+	MCR	15, 0, R2, C13, C0, 1
+	WORD	$0xf57ff06f			// isb sy
+
+	// set TTBR0
+	MCR	15, 0, R0, C2, C0, 0
+	WORD	$0xf57ff06f			// isb sy
+
+	// set CONTEXTIDR (PROCID, ASID)
+	MCR	15, 0, R1, C13, C0, 1
+	WORD	$0xf57ff06f			// isb sy
+
+	RET
+
+// func flush_tlb_asid(asid uint32)
+TEXT ·flush_tlb_asid(SB),NOSPLIT,$0-4
+	MOVW	asid+0(FP), R0
+
+	// data Synchronization Barrier
+	WORD	$0xf57ff04f			// dsb sy
+
+	// invalidate unified TLB by ASID (TLBIASID)
+	MCR	15, 0, R0, C8, C7, 2
+
+	WORD	$0xf57ff04f			// dsb sy
+	WORD	$0xf57ff06f			// isb sy
+
+	RET
@@ -0,0 +1,299 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"debug/elf"
+	"errors"
+	"fmt"
+
+	"github.com/usbarmory/tamago/dma"
+
+	"github.com/usbarmory/GoTEE/monitor/internal/sv39"
+)
+
+// defined in mmu_riscv64.s
+func read_satp() uint64
+func write_satp(val uint64)
+func sfence_vma(asid uint64)
+
+const pageSize = sv39.PageSize
+
+// tableMemory represents the memory region holding page table pages.
+type tableMemory struct {
+	mem *dma.Region
+}
+
+// Alloc allocates a zeroed page table page.
+func (m *tableMemory) Alloc() (addr uint64, buf []byte) {
+	a, buf := m.mem.Reserve(pageSize, pageSize)
+	clear(buf)
+
+	return uint64(a), buf
+}
+
+// Free returns the number of page aligned pages within the region free blocks.
+func (m *tableMemory) Free() (n int) {
+	for addr, size := range m.mem.FreeBlocks() {
+		start := (addr + pageSize - 1) &^ (pageSize - 1)
+		end := (addr + size) &^ (pageSize - 1)
+
+		if end > start {
+			n += int((end - start) / pageSize)
+		}
+	}
+
+	return
+}
+
+// Release frees a page table page.
+func (m *tableMemory) Release(addr uint64) {
+	m.mem.Release(uint(addr))
+}
+
+// PageTable represents an Sv39 page table, tagged with an Address Space
+// Identifier (ASID), which is activated through the satp register while a
+// Supervisor or User mode execution context is scheduled.
+//
+// All page table pages are allocated within a memory region owned by the
+// execution context, as hardware page walks are subject to its physical
+// memory protection, such region must not be mapped by the page table itself.
+type PageTable struct {
+	// ASID is the Address Space Identifier
+	ASID int
+	// User flags all subsequent mappings as accessible only in User mode,
+	// it must be set for User mode execution contexts.
+	User bool
+
+	// page table encoder
+	table *sv39.Table
+
+	// satp value before activation
+	satp uint64
+}
+
+// NewPageTable allocates an Sv39 root page table, for the argument ASID,
+// within the argument memory region. Additional page table pages are
+// allocated, on demand, within the same region.
+func NewPageTable(asid int, mem *dma.Region) (pt *PageTable, err error) {
+	if asid < 0 || asid > sv39.MaxASID {
+		return nil, fmt.Errorf("invalid ASID %d", asid)
+	}
+
+	if mem == nil {
+		return nil, errors.New("invalid page table region")
+	}
+
+	table, err := sv39.New(&tableMemory{mem: mem})
+
+	if err != nil {
+		return
+	}
+
+	return &PageTable{
+		ASID:  asid,
+		table: table,
+	}, nil
+}
+
+// Release frees the page table memory, the table must not be used after it
+// is released.
+func (pt *PageTable) Release() {
+	pt.table.Release()
+}
+
+// SATP returns the satp register value which activates the page table.
+func (pt *PageTable) SATP() uint64 {
+	return pt.table.SATP(pt.ASID)
+}
+
+// Map maps size bytes of physical memory, starting at pa, to virtual address
+// va with the argument attribute flags (see MapRead, MapWrite, MapExecute).
+//
+// Write permission implies read permission, while MapDevice is ignored as Sv39
+// provides no memory type attributes.
+//
+// Addresses and sizes must be aligned to 4KB, 2MB aligned ranges are mapped
+// with megapages while the remainder is mapped with 4KB pages.
+//
+// An error is returned, without any change to the page table, if the page
+// table region cannot hold the required page table pages.
+func (pt *PageTable) Map(va uint64, pa uint64, size uint64, flags int) (err error) {
+	var attr int
+
+	if flags&MapRead != 0 {
+		attr |= sv39.Read
+	}
+
+	if flags&MapWrite != 0 {
+		attr |= sv39.Write
+	}
+
+	if flags&MapExecute != 0 {
+		attr |= sv39.Execute
+	}
+
+	if pt.User {
+		attr |= sv39.User
+	}
+
+	defer pt.flush()
+
+	return pt.table.Map(va, pa, size, attr)
+}
+
+// Unmap removes size bytes of virtual memory, starting at va, from the page
+// table. Addresses and sizes must match the granularity of their existing
+// mappings.
+func (pt *PageTable) Unmap(va uint64, size uint64) (err error) {
+	defer pt.flush()
+	return pt.table.Unmap(va, size)
+}
+
+// Walk translates a virtual address by walking the page table, it returns the
+// corresponding physical address and mapping attribute flags.
+func (pt *PageTable) Walk(va uint64) (pa uint64, flags int, err error) {
+	pa, attr, err := pt.table.Walk(va)
+
+	if err != nil {
+		return
+	}
+
+	if attr&sv39.Read != 0 {
+		flags |= MapRead
+	}
+
+	if attr&sv39.Write != 0 {
+		flags |= MapWrite
+	}
+
+	if attr&sv39.Execute != 0 {
+		flags |= MapExecute
+	}
+
+	return
+}
+
+// MapELF maps the loadable segments of an ELF image with permissions derived
+// from their flags, segments which are both writable and executable are
+// rejected to enforce W^X.
+func (pt *PageTable) MapELF(f *elf.File) (err error) {
+	for _, prg := range f.Progs {
+		if prg.Type != elf.PT_LOAD || prg.Memsz == 0 {
+			continue
+		}
+
+		var flags int
+
+		if prg.Flags&elf.PF_R != 0 {
+			flags |= MapRead
+		}
+
+		if prg.Flags&elf.PF_W != 0 {
+			flags |= MapWrite
+		}
+
+		if prg.Flags&elf.PF_X != 0 {
+			flags |= MapExecute
+		}
+
+		if flags&MapWrite != 0 && flags&MapExecute != 0 {
+			return fmt.Errorf("W^X violation in segment at %#x", prg.Vaddr)
+		}
+
+		va := prg.Vaddr &^ (pageSize - 1)
+		pa := prg.Paddr &^ (pageSize - 1)
+		end := (prg.Vaddr + prg.Memsz + pageSize - 1) &^ (pageSize - 1)
+
+		if err = pt.Map(va, pa, end-va, flags); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (pt *PageTable) flush() {
+	sfence_vma(uint64(pt.ASID))
+}
+
+// activate switches the current address translation to the PageTable one.
+func (pt *PageTable) activate() {
+	pt.satp = read_satp()
+	write_satp(pt.SATP())
+	sfence_vma(uint64(pt.ASID))
+}
+
+// deactivate restores the previous address translation.
+func (pt *PageTable) deactivate() {
+	write_satp(pt.satp)
+	sfence_vma(uint64(pt.ASID))
+}
+
+// InitPageTable assigns a new PageTable, for the argument ASID, to the
+// execution context.
+//
+// The page table pages are allocated within the argument region, which must be
+// contained in the execution context Memory, and is not mapped. The loadable
+// segments of the argument ELF image are mapped with W^X permissions while the
+// remaining Memory is mapped as read/write, all mappings are flat (virtual
+// addresses match physical ones).
+func (ctx *ExecCtx) InitPageTable(asid int, tables *dma.Region, image *elf.File) (err error) {
+	start := uint64(ctx.Memory.Start())
+	end := uint64(ctx.Memory.End())
+
+	if tables == nil || uint64(tables.Start()) < start || uint64(tables.End()) > end {
+		return errors.New("page table region must be within execution context memory")
+	}
+
+	pt, err := NewPageTable(asid, tables)
+
+	if err != nil {
+		return
+	}
+
+	pt.User = ctx.user
+
+	defer func() {
+		if err != nil {
+			pt.Release()
+		}
+	}()
+
+	if image != nil {
+		if err = pt.MapELF(image); err != nil {
+			return
+		}
+	}
+
+	tablesStart := uint64(tables.Start()) &^ (pageSize - 1)
+	tablesEnd := uint64(tables.End())
+
+	// map remaining memory in contiguous unmapped ranges
+	var run uint64
+
+	for va := start &^ (pageSize - 1); va <= end; va += pageSize {
+		_, _, walkErr := pt.Walk(va)
+
+		free := va < end && walkErr != nil &&
+			(va+pageSize <= tablesStart || va >= tablesEnd)
+
+		switch {
+		case free && run == 0:
+			run = va
+		case !free && run != 0:
+			if err = pt.Map(run, run, va-run, MapRead|MapWrite); err != nil {
+				return
+			}
+
+			run = 0
+		}
+	}
+
+	ctx.PageTable = pt
+
+	return
+}
@@ -0,0 +1,31 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+#include "go_asm_riscv64.h"
+
+#define SFENCE_VMA(RS1,RS2) WORD $(0x12000073 + RS1<<15 + RS2<<20)
+
+// func read_satp() uint64
+TEXT ·read_satp(SB),NOSPLIT,$0-8
+	CSRR(satp, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func write_satp(val uint64)
+TEXT ·write_satp(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRW(t0, satp)
+
+	RET
+
+// func sfence_vma(asid uint64)
+TEXT ·sfence_vma(SB),NOSPLIT,$0-8
+	MOV	asid+0(FP), T0
+	SFENCE_VMA(0, t0)
+
+	RET
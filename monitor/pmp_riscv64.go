@@ -0,0 +1,232 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"errors"
+
+	"github.com/usbarmory/tamago/soc/sifive/fu540"
+
+	"github.com/usbarmory/GoTEE/monitor/internal/pmp"
+)
+
+// MaxPMPEntries is the number of PMP entries supported by the underlying
+// riscv64 package.
+const MaxPMPEntries = 8
+
+// Machine Security Configuration (mseccfg) fields
+// (2.2 Machine Security Configuration, RISC-V Smepmp Extension v1.0).
+const (
+	MSECCFG_MML  = 0 // Machine Mode Lockdown
+	MSECCFG_MMWP = 1 // Machine Mode Whitelist Policy
+	MSECCFG_RLB  = 2 // Rule Locking Bypass
+)
+
+// defined in pmp_riscv64.s
+func read_mseccfg() uint64
+func write_mseccfg(val uint64)
+
+// DefaultPMP represents the hart PMP entries which are configured at each
+// execution context Schedule(), locked entries are retained across execution
+// contexts.
+var DefaultPMP = NewPMP(MaxPMPEntries)
+
+// PMPEntry represents a Physical Memory Protection region.
+type PMPEntry struct {
+	// Start is the region start address (inclusive)
+	Start uint64
+	// End is the region end address (exclusive)
+	End uint64
+
+	// R, W, X represent the region access permissions
+	R bool
+	W bool
+	X bool
+
+	// Lock represents the entry lock state
+	Lock bool
+
+	// A is the address-matching mode (riscv64.PMP_A_*)
+	A int
+	// Addr is the entry address argument for riscv64.CPU.WritePMP()
+	Addr uint64
+}
+
+// PMP represents a Physical Memory Protection entry allocator, entries are
+// allocated in priority order and their address-matching mode is selected
+// automatically from the region alignment.
+type PMP struct {
+	// Smepmp must be set only if the hart implements the Smepmp (ePMP)
+	// extension, it enables use of the mseccfg register and rule locking
+	// bypass.
+	Smepmp bool
+
+	alloc *pmp.Allocator
+}
+
+// NewPMP returns a Physical Memory Protection entry allocator for the
+// argument number of entries.
+func NewPMP(n int) *PMP {
+	return &PMP{
+		alloc: pmp.New(n),
+	}
+}
+
+// next returns the first entry index after the last used one.
+func (p *PMP) next() int {
+	return p.alloc.Next()
+}
+
+// Entry returns the PMP entry at the argument index.
+func (p *PMP) Entry(i int) (e PMPEntry, err error) {
+	if i < 0 || i >= len(p.alloc.Entries) {
+		return e, errors.New("invalid PMP index")
+	}
+
+	a := p.alloc.Entries[i]
+
+	return PMPEntry{
+		Start: a.Start,
+		End:   a.End,
+		R:     a.R,
+		W:     a.W,
+		X:     a.X,
+		Lock:  a.Lock,
+		A:     a.A,
+		Addr:  a.Addr,
+	}, nil
+}
+
+// Add allocates PMP entries for the argument region and access permissions,
+// returning the index of the entry that matches it.
+//
+// NAPOT or NA4 encoding is used for naturally aligned regions, otherwise TOR
+// encoding is used which requires an additional entry unless the previous one
+// ends at the region start.
+//
+// Entries are allocated at the first available index, an error is returned
+// if the region overlaps with any entry of higher priority, as it would take
+// precedence. Overlapping lower priority entries (see AddAt()) is allowed.
+func (p *PMP) Add(start uint64, end uint64, r bool, w bool, x bool) (i int, err error) {
+	return p.alloc.Add(start, end, r, w, x)
+}
+
+// AddAt allocates PMP entries for the argument region and access permissions
+// at the argument index, TOR encoded regions might require an additional
+// entry preceding it.
+//
+// It allows to place entries at a lower priority than subsequent Add()
+// allocations, such as a locked entry granting machine mode access to all
+// memory (see SetSecurityConfig()) at the last index.
+func (p *PMP) AddAt(i int, start uint64, end uint64, r bool, w bool, x bool) (err error) {
+	return p.alloc.AddAt(i, start, end, r, w, x)
+}
+
+// Lock locks the PMP entry at the argument index, locked entries are enforced
+// also on machine mode and cannot be modified until the next hart reset,
+// unless rule locking bypass is enabled (see SetSecurityConfig()).
+//
+// Locking a TOR entry also locks its base entry address.
+func (p *PMP) Lock(i int) (err error) {
+	return p.alloc.Lock(i)
+}
+
+// Remove frees the PMP entry at the argument index, locked entries can only
+// be removed when rule locking bypass is enabled.
+func (p *PMP) Remove(i int) (err error) {
+	if i < 0 || i >= len(p.alloc.Entries) {
+		return errors.New("invalid PMP index")
+	}
+
+	_, _, rlb := p.SecurityConfig()
+
+	return p.alloc.Remove(i, rlb)
+}
+
+// Reset frees all unlocked PMP entries.
+func (p *PMP) Reset() {
+	p.alloc.Reset()
+}
+
+// Read loads all entries from the hart PMP CSRs, it can be used to retain
+// entries configured before the Trusted OS execution.
+func (p *PMP) Read() (err error) {
+	var addr uint64
+	var r, w, x, lock bool
+	var a int
+
+	for i := range p.alloc.Entries {
+		if addr, r, w, x, a, lock, err = fu540.RV64.ReadPMP(i); err != nil {
+			return
+		}
+
+		if err = p.alloc.Set(i, addr, r, w, x, a, lock); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// Write configures the hart PMP CSRs with all entries.
+func (p *PMP) Write() (err error) {
+	for i, e := range p.alloc.Entries {
+		if err = fu540.RV64.WritePMP(i, e.Addr, e.R, e.W, e.X, e.A, e.Lock); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+// SetSecurityConfig sets the Smepmp Machine Security Configuration register,
+// it has no effect unless the Smepmp field is set.
+//
+// Once set the Machine Mode Lockdown (MML) and Machine Mode Whitelist Policy
+// (MMWP) bits cannot be cleared until the next hart reset, therefore locked
+// entries granting machine mode access to the Trusted OS memory must be in
+// place before they are enabled.
+//
+// Such entries are meant to be placed at the lowest priority (see AddAt()),
+// so that execution context entries allocated at Schedule() can overlap
+// them.
+func (p *PMP) SetSecurityConfig(mml bool, mmwp bool, rlb bool) {
+	var val uint64
+
+	if !p.Smepmp {
+		return
+	}
+
+	if mml {
+		val |= 1 << MSECCFG_MML
+	}
+
+	if mmwp {
+		val |= 1 << MSECCFG_MMWP
+	}
+
+	if rlb {
+		val |= 1 << MSECCFG_RLB
+	}
+
+	write_mseccfg(val)
+}
+
+// SecurityConfig returns the Smepmp Machine Security Configuration register
+// state, all values are false unless the Smepmp field is set.
+func (p *PMP) SecurityConfig() (mml bool, mmwp bool, rlb bool) {
+	if !p.Smepmp {
+		return
+	}
+
+	val := read_mseccfg()
+
+	mml = (val>>MSECCFG_MML)&1 == 1
+	mmwp = (val>>MSECCFG_MMWP)&1 == 1
+	rlb = (val>>MSECCFG_RLB)&1 == 1
+
+	return
+}
@@ -0,0 +1,22 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+#include "go_asm_riscv64.h"
+
+// func read_mseccfg() uint64
+TEXT ·read_mseccfg(SB),NOSPLIT,$0-8
+	CSRR(mseccfg, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func write_mseccfg(val uint64)
+TEXT ·write_mseccfg(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRW(t0, mseccfg)
+
+	RET
@@ -0,0 +1,64 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/GoTEE/monitor/internal/psci"
+)
+
+// PSCI version implemented by the PSCI service.
+const (
+	PSCI_MAJOR = psci.PSCI_MAJOR
+	PSCI_MINOR = psci.PSCI_MINOR
+)
+
+// PSCI Function IDs (SMC32)
+// (5.1 Function prototypes, DEN0022 - Arm Power State Coordination Interface).
+const (
+	PSCI_VERSION           = psci.PSCI_VERSION
+	PSCI_CPU_SUSPEND       = psci.PSCI_CPU_SUSPEND
+	PSCI_CPU_OFF           = psci.PSCI_CPU_OFF
+	PSCI_CPU_ON            = psci.PSCI_CPU_ON
+	PSCI_AFFINITY_INFO     = psci.PSCI_AFFINITY_INFO
+	PSCI_MIGRATE_INFO_TYPE = psci.PSCI_MIGRATE_INFO_TYPE
+	PSCI_SYSTEM_OFF        = psci.PSCI_SYSTEM_OFF
+	PSCI_SYSTEM_RESET      = psci.PSCI_SYSTEM_RESET
+	PSCI_FEATURES          = psci.PSCI_FEATURES
+)
+
+// PSCI return codes
+// (5.2.2 Return error codes, DEN0022).
+const (
+	PSCI_SUCCESS            = psci.PSCI_SUCCESS
+	PSCI_NOT_SUPPORTED      = psci.PSCI_NOT_SUPPORTED
+	PSCI_INVALID_PARAMETERS = psci.PSCI_INVALID_PARAMETERS
+	PSCI_DENIED             = psci.PSCI_DENIED
+	PSCI_ALREADY_ON         = psci.PSCI_ALREADY_ON
+	PSCI_ON_PENDING         = psci.PSCI_ON_PENDING
+	PSCI_INTERNAL_FAILURE   = psci.PSCI_INTERNAL_FAILURE
+	PSCI_NOT_PRESENT        = psci.PSCI_NOT_PRESENT
+	PSCI_DISABLED           = psci.PSCI_DISABLED
+	PSCI_INVALID_ADDRESS    = psci.PSCI_INVALID_ADDRESS
+)
+
+// PSCI affinity states (AFFINITY_INFO)
+const (
+	PSCI_AFFINITY_ON         = psci.PSCI_AFFINITY_ON
+	PSCI_AFFINITY_OFF        = psci.PSCI_AFFINITY_OFF
+	PSCI_AFFINITY_ON_PENDING = psci.PSCI_AFFINITY_ON_PENDING
+)
+
+// PSCI represents a Power State Coordination Interface service for
+// non-secure execution contexts, to be registered on the SMC Calling
+// Convention dispatcher (see SMC) to let Normal World kernels (e.g. Linux)
+// manage cores and system power states without modification.
+//
+// Cores are identified by the MPIDR affinity level 0 field, the core 0
+// execution context is set with the Primary field or, if not set, is the
+// first one to invoke the service. Core and system power operations are
+// delegated to the Trusted OS through the PSCI callbacks (CPUOn, CPUOff,
+// SystemOff, SystemReset), operations without a callback are not supported.
+type PSCI = psci.Service[*ExecCtx]
@@ -0,0 +1,114 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"slices"
+)
+
+// Input kinds
+const (
+	// InputReturn represents system or monitor call return registers
+	// (see Ret(), Return()).
+	InputReturn = iota
+	// InputMemory represents a write to execution context memory (see
+	// Poke()).
+	InputMemory
+	// InputCounter represents an emulated read of a time or performance
+	// counter.
+	InputCounter
+	// InputInterrupt represents an interrupt caught while the execution
+	// context was running.
+	InputInterrupt
+	// InputException represents a synchronous exception, it delimits the
+	// inputs provided at each exception in recorded streams (see Record).
+	InputException
+)
+
+// Input represents a nondeterministic input provided by the monitor to an
+// execution context.
+type Input struct {
+	// Kind is the input kind
+	Kind int
+	// Cycle is the execution context scheduling cycle at input
+	Cycle uint
+
+	// Values holds the return register values (InputReturn), the counter
+	// value (InputCounter), the exception vector/cause (InputInterrupt) or
+	// the exception vector/cause followed by the register state
+	// (InputException).
+	Values []uint64
+
+	// Offset is the memory offset of written data (InputMemory)
+	Offset int
+	// Data is the written data (InputMemory)
+	Data []byte
+}
+
+// inputLog represents the inputs recorded from a primary execution context
+// for replay into its Shadow.
+type inputLog struct {
+	// inputs recorded since the last lockstep comparison
+	inputs []Input
+	// index of the next input to replay
+	replayed int
+	// nondeterministic events, not recorded, since the last comparison
+	unrecorded int
+}
+
+// next returns the next input of the argument kind to be replayed.
+func (l *inputLog) next(kind int) (in *Input, ok bool) {
+	for ; l.replayed < len(l.inputs); l.replayed++ {
+		if l.inputs[l.replayed].Kind == kind {
+			in = &l.inputs[l.replayed]
+			l.replayed += 1
+			return in, true
+		}
+	}
+
+	return
+}
+
+// pending returns whether inputs of the argument kind have been recorded
+// but not replayed.
+func (l *inputLog) pending(kind int) bool {
+	for _, in := range l.inputs[l.replayed:] {
+		if in.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reset clears the log at each lockstep comparison.
+func (l *inputLog) reset() {
+	l.inputs = nil
+	l.replayed = 0
+	l.unrecorded = 0
+}
+
+// record logs an input provided by the monitor to the execution context, for
+// deterministic lockstep (see Deterministic field) or its recording (see
+// Record field).
+func (ctx *ExecCtx) record(kind int, values []uint64, off int, data []byte) {
+	in := Input{
+		Kind:   kind,
+		Cycle:  ctx.cycles,
+		Values: values,
+		Offset: off,
+		Data:   slices.Clone(data),
+	}
+
+	// interrupts are handled again, rather than replayed
+	if ctx.Record != nil && !ctx.interrupt() {
+		ctx.rec.inputs = append(ctx.rec.inputs, in)
+	}
+
+	if ctx.Deterministic && ctx.Shadow != nil {
+		ctx.log.inputs = append(ctx.log.inputs, in)
+	}
+}
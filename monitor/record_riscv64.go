@@ -0,0 +1,129 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/tamago/riscv64"
+	"github.com/usbarmory/tamago/soc/sifive/fu540"
+)
+
+// Counter CSRs
+// (Table 2.2 - Volume II: RISC-V Privileged Architectures V20211203).
+const (
+	CSR_CYCLE   = 0xc00
+	CSR_TIME    = 0xc01
+	CSR_INSTRET = 0xc02
+)
+
+// defined in record_riscv64.s
+func read_mcounteren() uint64
+func write_mcounteren(val uint64)
+func read_mcycle() uint64
+func read_minstret() uint64
+
+// counter returns the current value of the argument counter CSR.
+func counter(csr uint64) (val uint64, ok bool) {
+	switch csr {
+	case CSR_CYCLE:
+		return read_mcycle(), true
+	case CSR_TIME:
+		return fu540.CLINT.Mtime(), true
+	case CSR_INSTRET:
+		return read_minstret(), true
+	}
+
+	return
+}
+
+// counterRead decodes a counter read (csrr rd, csr) trapped as illegal
+// instruction.
+func (ctx *ExecCtx) counterRead() (rd int, csr uint64, ok bool) {
+	if code, irq := ctx.Cause(); irq || code != riscv64.IllegalInstruction {
+		return
+	}
+
+	insn := ctx.MTVAL
+
+	// CSRRS rd, csr, x0 (2.8 Control and Status Register Instructions,
+	// Volume I: RISC-V Unprivileged ISA V20191213).
+	opcode := insn & 0x7f
+	rd = int(insn>>7) & 0x1f
+	funct3 := (insn >> 12) & 0b111
+	rs1 := (insn >> 15) & 0x1f
+	csr = (insn >> 20) & 0xfff
+
+	ok = opcode == 0b1110011 && funct3 == 0b010 && rs1 == 0
+
+	return
+}
+
+// replayCounter completes a trapped counter read with the argument value.
+func (ctx *ExecCtx) replayCounter(val uint64) bool {
+	rd, _, ok := ctx.counterRead()
+
+	if ok {
+		(&target{ctx: ctx}).SetRegister(rd, val)
+	}
+
+	return ok
+}
+
+// emulate handles counter reads trapped as illegal instructions in
+// deterministic lockstep (see Deterministic field).
+//
+// The counter value is read and recorded for a primary execution context, or
+// replayed from the argument primary execution context for a Shadow one. The
+// function returns whether the trap has been handled.
+func (ctx *ExecCtx) emulate(primary *ExecCtx) bool {
+	rd, csr, ok := ctx.counterRead()
+
+	if !ok {
+		return false
+	}
+
+	var val uint64
+
+	if primary == nil {
+		v, ok := counter(csr)
+
+		if !ok {
+			return false
+		}
+
+		val = v
+		ctx.record(InputCounter, []uint64{val}, 0, nil)
+	} else {
+		in, ok := primary.log.next(InputCounter)
+
+		if !ok {
+			primary.log.unrecorded += 1
+			return false
+		}
+
+		val = in.Values[0]
+	}
+
+	(&target{ctx: ctx}).SetRegister(rd, val)
+
+	return true
+}
+
+// replicate completes the counter reads trapped by Replicas with the value
+// emulated for the primary execution context, as Replicas are voted on but
+// never handled.
+func (ctx *ExecCtx) replicate() {
+	rd, _, ok := ctx.counterRead()
+
+	if !ok {
+		return
+	}
+
+	val := (&target{ctx: ctx}).Register(rd)
+
+	for _, r := range ctx.Replicas {
+		r.replayCounter(val)
+	}
+}
@@ -0,0 +1,36 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+#include "go_asm_riscv64.h"
+
+// func read_mcounteren() uint64
+TEXT ·read_mcounteren(SB),NOSPLIT,$0-8
+	CSRR(mcounteren, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func write_mcounteren(val uint64)
+TEXT ·write_mcounteren(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRW(t0, mcounteren)
+
+	RET
+
+// func read_mcycle() uint64
+TEXT ·read_mcycle(SB),NOSPLIT,$0-8
+	CSRR(mcycle, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func read_minstret() uint64
+TEXT ·read_minstret(SB),NOSPLIT,$0-8
+	CSRR(minstret, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
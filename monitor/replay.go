@@ -0,0 +1,147 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// recording represents the execution context record and replay state.
+type recording struct {
+	// inputs recorded in the current scheduling cycle
+	inputs []Input
+	// replay stream decoder
+	dec *json.Decoder
+	// next input to be replayed
+	next *Input
+}
+
+// mark records a synchronous exception along with the execution context
+// register state.
+func (ctx *ExecCtx) mark() {
+	if ctx.Record == nil || ctx.interrupt() {
+		return
+	}
+
+	ctx.record(InputException, append([]uint64{ctx.exception()}, ctx.state()...), 0, nil)
+}
+
+// save writes the inputs recorded in the current scheduling cycle to the
+// Record stream.
+func (ctx *ExecCtx) save() (err error) {
+	enc := json.NewEncoder(ctx.Record)
+
+	for _, in := range ctx.rec.inputs {
+		if err = enc.Encode(in); err != nil {
+			return
+		}
+	}
+
+	ctx.rec.inputs = nil
+
+	return
+}
+
+// peek returns the next input to be replayed from the Replay stream.
+func (ctx *ExecCtx) peek() (in *Input, err error) {
+	if ctx.rec.next != nil {
+		return ctx.rec.next, nil
+	}
+
+	if ctx.rec.dec == nil {
+		ctx.rec.dec = json.NewDecoder(ctx.Replay)
+	}
+
+	in = &Input{}
+
+	if err = ctx.rec.dec.Decode(in); err != nil {
+		return nil, err
+	}
+
+	ctx.rec.next = in
+
+	return
+}
+
+// apply replays a recorded input.
+func (ctx *ExecCtx) apply(in *Input) (err error) {
+	switch in.Kind {
+	case InputReturn:
+		ctx.Return(in.Values...)
+	case InputMemory:
+		if in.Offset < 0 || in.Offset+len(in.Data) > int(ctx.Memory.Size()) {
+			return errors.New("invalid replay memory offset")
+		}
+
+		ctx.Poke(in.Offset, in.Data)
+	case InputCounter:
+		if len(in.Values) != 1 || !ctx.replayCounter(in.Values[0]) {
+			return errors.New("invalid replay counter")
+		}
+	}
+
+	return
+}
+
+// replay applies, at each synchronous exception, the inputs recorded for it
+// on the Replay stream. An error is returned if the execution context state
+// differs from the recorded one, the execution context is stopped once the
+// last recorded exception is replayed.
+func (ctx *ExecCtx) replay() (err error) {
+	in, err := ctx.peek()
+
+	if err == io.EOF {
+		ctx.Stop()
+		return nil
+	} else if err != nil {
+		return
+	}
+
+	ctx.rec.next = nil
+
+	if in.Kind != InputException || len(in.Values) == 0 {
+		return fmt.Errorf("invalid replay input at cycle %d", ctx.cycles)
+	}
+
+	expected := *ctx
+
+	if in.Values[0] != ctx.exception() || !expected.setState(in.Values[1:]) {
+		return fmt.Errorf("replay divergence at cycle %d, exception %#x (expected %#x)", ctx.cycles, ctx.exception(), in.Values[0])
+	}
+
+	if d := diff(&expected, ctx); len(d) > 0 {
+		var fields []string
+
+		for _, f := range d {
+			fields = append(fields, f.Field)
+		}
+
+		return fmt.Errorf("replay divergence at cycle %d, differing: %s", ctx.cycles, strings.Join(fields, ","))
+	}
+
+	for {
+		if in, err = ctx.peek(); err == io.EOF {
+			ctx.Stop()
+			return nil
+		} else if err != nil {
+			return
+		}
+
+		if in.Kind == InputException {
+			return
+		}
+
+		ctx.rec.next = nil
+
+		if err = ctx.apply(in); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"errors"
+	"slices"
+)
+
+// snapshot represents the pristine state of an execution context.
+type snapshot struct {
+	// register state
+	ctx ExecCtx
+	// Memory contents
+	mem []byte
+}
+
+// Snapshot saves the execution context register state and Memory contents as
+// its pristine state, to be restored with Reset(). It is meant to be invoked
+// once the executable image is loaded, before its first Run().
+//
+// The Memory contents are copied, therefore the snapshot requires as much
+// memory as the execution context one.
+func (ctx *ExecCtx) Snapshot() {
+	s := &snapshot{
+		ctx: *ctx,
+		mem: slices.Clone(mem(ctx.Memory.Start(), int(ctx.Memory.Size()))),
+	}
+
+	s.ctx.pristine = nil
+	ctx.pristine = s
+}
+
+// Reset restores the execution context pristine state (see Snapshot()),
+// discarding buffered Read()/Write() data, to allow its restart.
+func (ctx *ExecCtx) Reset() (err error) {
+	s := ctx.pristine
+
+	if s == nil {
+		return errors.New("no pristine state")
+	}
+
+	if int(ctx.Memory.Size()) != len(s.mem) {
+		return errors.New("pristine state memory mismatch")
+	}
+
+	ctx.setRegisters(&s.ctx)
+	copy(mem(ctx.Memory.Start(), len(s.mem)), s.mem)
+
+	ctx.in = nil
+	ctx.out = nil
+	ctx.log = inputLog{}
+	ctx.rec = recording{}
+
+	return
+}
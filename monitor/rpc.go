@@ -6,6 +6,7 @@
 package monitor
 
 import (
+	"errors"
 	"fmt"
 	"net/rpc/jsonrpc"
 
@@ -40,7 +41,7 @@ func (ctx *ExecCtx) Write(p []byte) (int, error) {
 }
 
 // Poke writes buffer contents to the execution context memory, including its
-// Shadow if present, at a given offset.
+// Shadow and Replicas if present, at a given offset.
 func (ctx *ExecCtx) Poke(off int, buf []byte) {
 	if ctx.MMU != nil {
 		ctx.MMU()
@@ -49,9 +50,14 @@ func (ctx *ExecCtx) Poke(off int, buf []byte) {
 	addr := ctx.Memory.Start()
 	ctx.Memory.Write(addr, off, buf)
 
-	if ctx.Shadow != nil {
-		ctx.Shadow.Poke(off, buf)
-		ctx.MMU()
+	ctx.record(InputMemory, nil, off, buf)
+
+	for _, r := range ctx.redundant() {
+		r.Poke(off, buf)
+
+		if ctx.MMU != nil {
+			ctx.MMU()
+		}
 	}
 }
 
@@ -69,12 +75,18 @@ func (ctx *ExecCtx) Recv() (err error) {
 		return
 	}
 
+	ctx.recv(off, n)
+
+	return nil
+}
+
+// recv buffers n bytes of the execution context memory at the argument
+// offset (see Read()).
+func (ctx *ExecCtx) recv(off int, n int) {
 	buf := make([]byte, n)
 
 	ctx.Memory.Read(ctx.Memory.Start(), off, buf)
 	ctx.in = append(ctx.in, buf...)
-
-	return nil
 }
 
 // Flush handles syscall.Read() as received from the execution context, the
@@ -111,6 +123,48 @@ func (ctx *ExecCtx) Flush(errno int) (n int, err error) {
 	return n, nil
 }
 
+// Request handles a JSON-RPC request, read from the execution context memory
+// at the argument address and size, through the context Server. The response
+// is buffered until returned to the execution context with Response().
+//
+// It allows RPC over calling conventions other than GoTEE system calls (e.g.
+// SBI).
+func (ctx *ExecCtx) Request(addr uint, n int) (err error) {
+	off, err := ctx.MemoryRegion(addr, n)
+
+	if err != nil {
+		return
+	}
+
+	if ctx.Server == nil {
+		return errors.New("no RPC server")
+	}
+
+	ctx.recv(off, n)
+
+	return ctx.Server.ServeRequest(jsonrpc.NewServerCodec(ctx))
+}
+
+// Response returns buffered JSON-RPC responses (see Request()) to the
+// execution context memory at the argument address, up to the argument size,
+// the number of bytes written is returned.
+func (ctx *ExecCtx) Response(addr uint, n int) (w int, err error) {
+	off, err := ctx.MemoryRegion(addr, n)
+
+	if err != nil {
+		return
+	}
+
+	if w = len(ctx.out); w > n {
+		w = n
+	}
+
+	ctx.Poke(off, ctx.out[0:w])
+	ctx.out = ctx.out[w:]
+
+	return
+}
+
 func (ctx *ExecCtx) rpc() (err error) {
 	switch num := ctx.A0(); num {
 	case syscall.SYS_RPC_REQ:
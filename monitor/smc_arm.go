@@ -0,0 +1,40 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/tamago/arm"
+
+	"github.com/usbarmory/GoTEE/smccc"
+)
+
+// SMC is the SMC Calling Convention dispatcher used by NonSecureHandler to
+// route secure monitor calls to registered services, the execution context is
+// passed as call Context.
+var SMC = smccc.NewDispatcher()
+
+// monitorCall handles secure monitor calls raised by a non-secure execution
+// context through the SMC dispatcher.
+func (ctx *ExecCtx) monitorCall() (err error) {
+	if ctx.ExceptionVector != arm.SUPERVISOR {
+		return
+	}
+
+	call := &smccc.Call{
+		Regs: [8]uint64{
+			uint64(ctx.R0), uint64(ctx.R1), uint64(ctx.R2), uint64(ctx.R3),
+			uint64(ctx.R4), uint64(ctx.R5), uint64(ctx.R6), uint64(ctx.R7),
+		},
+		AArch32: true,
+		Context: ctx,
+	}
+
+	err = SMC.Dispatch(call)
+
+	ctx.Return(call.Regs[0], call.Regs[1], call.Regs[2], call.Regs[3])
+
+	return
+}
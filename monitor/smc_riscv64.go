@@ -0,0 +1,22 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+// SBI error code returned to unhandled supervisor calls
+// (Table 1. Standard SBI Errors, RISC-V Supervisor Binary Interface
+// Specification v1.0.0).
+const sbiNotSupported = -2
+
+// monitorCall handles supervisor calls raised by a non-secure execution
+// context, on RISC-V these are meant to be handled by an SBI implementation
+// (see sbi.Handler), all calls are therefore returned SBI_ERR_NOT_SUPPORTED.
+func (ctx *ExecCtx) monitorCall() (err error) {
+	var errno int64 = sbiNotSupported
+
+	ctx.Return(uint64(errno), 0)
+
+	return
+}
@@ -0,0 +1,394 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package supervisor implements lifecycle management of named execution
+// contexts, such as trusted applets, with restart policies.
+//
+// The package is architecture independent, supervised contexts are
+// represented by the Context interface which is implemented by
+// monitor.ExecCtx, it can therefore be used on any host with fake contexts.
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Restart policies
+const (
+	// RestartNever never restarts a context once its execution returns.
+	RestartNever = iota
+	// RestartOnFailure restarts a context only when its execution returns
+	// an error.
+	RestartOnFailure
+	// RestartAlways restarts a context whenever its execution returns.
+	RestartAlways
+)
+
+// Applet states
+const (
+	// Stopped indicates a context which is not running, either because
+	// never started, stopped or terminated without error.
+	Stopped = iota
+	// Running indicates a running context.
+	Running
+	// Restarting indicates a context waiting for its restart.
+	Restarting
+	// Crashed indicates a context which terminated with an error and is
+	// not restarted.
+	Crashed
+)
+
+// Context represents a supervised execution context (e.g. monitor.ExecCtx).
+type Context interface {
+	// RunContext runs the execution context until it returns or the
+	// argument context is done.
+	RunContext(ctx context.Context) error
+}
+
+// Resetter is implemented by contexts which can restore their pristine state
+// (e.g. monitor.ExecCtx), in which case Snapshot() is invoked when the context
+// is added and Reset() before each restart.
+type Resetter interface {
+	Snapshot()
+	Reset() error
+}
+
+// Policy represents a context restart policy.
+type Policy struct {
+	// Restart is the restart policy (RestartNever, RestartOnFailure,
+	// RestartAlways).
+	Restart int
+
+	// Backoff is the delay before a restart, it is doubled at each
+	// consecutive failure up to MaxBackoff.
+	Backoff time.Duration
+	// MaxBackoff is the maximum delay before a restart.
+	MaxBackoff time.Duration
+
+	// MaxRestarts, if not zero, is the maximum number of restarts within
+	// Window, once exceeded the context is considered crashed.
+	MaxRestarts int
+	// Window is the interval over which MaxRestarts is enforced.
+	Window time.Duration
+}
+
+// Status represents a supervised context status.
+type Status struct {
+	// Name is the context name
+	Name string
+	// State is the context state (Stopped, Running, Restarting, Crashed)
+	State int
+	// Restarts is the number of context restarts
+	Restarts int
+	// Err is the last error returned by the context execution
+	Err error
+	// Started is the time of the last context start
+	Started time.Time
+}
+
+// String returns a description of the status state.
+func (s *Status) String() string {
+	switch s.State {
+	case Stopped:
+		return "stopped"
+	case Running:
+		return "running"
+	case Restarting:
+		return "restarting"
+	case Crashed:
+		return "crashed"
+	default:
+		return fmt.Sprintf("invalid state %d", s.State)
+	}
+}
+
+// applet represents a supervised context.
+type applet struct {
+	ctx    Context
+	policy Policy
+	status Status
+
+	// restart times within policy window
+	restarts []time.Time
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Supervisor represents a set of named supervised contexts.
+type Supervisor struct {
+	mux sync.Mutex
+
+	applets map[string]*applet
+}
+
+// NewSupervisor returns a new supervisor instance.
+func NewSupervisor() *Supervisor {
+	return &Supervisor{
+		applets: make(map[string]*applet),
+	}
+}
+
+// Add adds a named context, with the argument restart policy, to the
+// supervisor. The context is not started (see Start()).
+func (s *Supervisor) Add(name string, ctx Context, policy Policy) (err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	if _, ok := s.applets[name]; ok {
+		return fmt.Errorf("%s already exists", name)
+	}
+
+	if policy.Restart < RestartNever || policy.Restart > RestartAlways {
+		return fmt.Errorf("invalid restart policy %d", policy.Restart)
+	}
+
+	if r, ok := ctx.(Resetter); ok {
+		r.Snapshot()
+	}
+
+	s.applets[name] = &applet{
+		ctx:    ctx,
+		policy: policy,
+		status: Status{Name: name},
+	}
+
+	return
+}
+
+// Remove stops and removes a named context from the supervisor.
+func (s *Supervisor) Remove(name string) (err error) {
+	if err = s.Stop(name); err != nil {
+		return
+	}
+
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	delete(s.applets, name)
+
+	return
+}
+
+func (s *Supervisor) get(name string) (a *applet, err error) {
+	a, ok := s.applets[name]
+
+	if !ok {
+		return nil, fmt.Errorf("%s not found", name)
+	}
+
+	return
+}
+
+// Start starts a named context, its execution is supervised in a separate
+// goroutine according to its restart policy.
+func (s *Supervisor) Start(name string) (err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	a, err := s.get(name)
+
+	if err != nil {
+		return
+	}
+
+	if a.done != nil {
+		return fmt.Errorf("%s already started", name)
+	}
+
+	c, cancel := context.WithCancel(context.Background())
+
+	a.cancel = cancel
+	a.done = make(chan struct{})
+	a.status.Err = nil
+
+	go s.supervise(c, a)
+
+	return
+}
+
+// Stop stops a named context and waits for its supervision to end.
+func (s *Supervisor) Stop(name string) (err error) {
+	s.mux.Lock()
+	a, err := s.get(name)
+
+	if err != nil {
+		s.mux.Unlock()
+		return
+	}
+
+	cancel := a.cancel
+	done := a.done
+	s.mux.Unlock()
+
+	if done == nil {
+		return
+	}
+
+	cancel()
+	<-done
+
+	return
+}
+
+// Status returns the status of a named context.
+func (s *Supervisor) Status(name string) (status Status, err error) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	a, err := s.get(name)
+
+	if err != nil {
+		return
+	}
+
+	return a.status, nil
+}
+
+// List returns the status of all contexts, sorted by name.
+func (s *Supervisor) List() (status []Status) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	for _, a := range s.applets {
+		status = append(status, a.status)
+	}
+
+	sort.Slice(status, func(i, j int) bool {
+		return status[i].Name < status[j].Name
+	})
+
+	return
+}
+
+func (s *Supervisor) setState(a *applet, state int) {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+
+	a.status.State = state
+}
+
+// restart returns the delay before the next context restart, ok is false if
+// the policy restart limit has been exceeded.
+func (a *applet) restart(failures int) (delay time.Duration, ok bool) {
+	now := time.Now()
+	p := a.policy
+
+	if p.MaxRestarts > 0 {
+		var recent []time.Time
+
+		for _, t := range a.restarts {
+			if now.Sub(t) < p.Window {
+				recent = append(recent, t)
+			}
+		}
+
+		if len(recent) >= p.MaxRestarts {
+			return 0, false
+		}
+
+		a.restarts = append(recent, now)
+	}
+
+	delay = p.Backoff
+
+	// exponential backoff, bounded to prevent overflow
+	for i := 1; i < failures && i < 32; i++ {
+		delay *= 2
+	}
+
+	if p.MaxBackoff > 0 && delay > p.MaxBackoff {
+		delay = p.MaxBackoff
+	}
+
+	return delay, true
+}
+
+// supervise runs a context, restarting it according to its policy, until
+// the argument context is done.
+func (s *Supervisor) supervise(c context.Context, a *applet) {
+	var failures int
+
+	defer func() {
+		s.mux.Lock()
+		defer s.mux.Unlock()
+
+		close(a.done)
+		a.done = nil
+		a.cancel = nil
+	}()
+
+	for {
+		s.mux.Lock()
+		a.status.State = Running
+		a.status.Started = time.Now()
+		s.mux.Unlock()
+
+		err := a.ctx.RunContext(c)
+
+		if c.Err() != nil {
+			s.setState(a, Stopped)
+			return
+		}
+
+		s.mux.Lock()
+		a.status.Err = err
+		s.mux.Unlock()
+
+		if err != nil {
+			failures += 1
+		} else {
+			failures = 0
+		}
+
+		if a.policy.Restart == RestartNever || a.policy.Restart == RestartOnFailure && err == nil {
+			if err != nil {
+				s.setState(a, Crashed)
+			} else {
+				s.setState(a, Stopped)
+			}
+
+			return
+		}
+
+		delay, ok := a.restart(failures)
+
+		if !ok {
+			s.mux.Lock()
+			a.status.State = Crashed
+			a.status.Err = errors.Join(err, errors.New("restart limit exceeded"))
+			s.mux.Unlock()
+			return
+		}
+
+		s.setState(a, Restarting)
+
+		select {
+		case <-c.Done():
+			s.setState(a, Stopped)
+			return
+		case <-time.After(delay):
+		}
+
+		if r, ok := a.ctx.(Resetter); ok {
+			if err = r.Reset(); err != nil {
+				s.mux.Lock()
+				a.status.State = Crashed
+				a.status.Err = err
+				s.mux.Unlock()
+				return
+			}
+		}
+
+		s.mux.Lock()
+		a.status.Restarts += 1
+		s.mux.Unlock()
+	}
+}
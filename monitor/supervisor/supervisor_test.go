@@ -0,0 +1,425 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errFail = errors.New("failure")
+
+// fakeContext is a supervised context which returns the configured errors, in
+// sequence, at each run and then blocks until canceled.
+type fakeContext struct {
+	sync.Mutex
+
+	errs []error
+
+	runs      int
+	snapshots int
+	resets    int
+	resetErr  error
+}
+
+func (f *fakeContext) RunContext(c context.Context) error {
+	f.Lock()
+	run := f.runs
+	f.runs += 1
+	f.Unlock()
+
+	if run < len(f.errs) {
+		return f.errs[run]
+	}
+
+	<-c.Done()
+
+	return c.Err()
+}
+
+func (f *fakeContext) Runs() int {
+	f.Lock()
+	defer f.Unlock()
+
+	return f.runs
+}
+
+// resetContext is a fakeContext implementing Resetter.
+type resetContext struct {
+	fakeContext
+}
+
+func (f *resetContext) Snapshot() {
+	f.Lock()
+	defer f.Unlock()
+
+	f.snapshots += 1
+}
+
+func (f *resetContext) Reset() error {
+	f.Lock()
+	defer f.Unlock()
+
+	f.resets += 1
+
+	return f.resetErr
+}
+
+// waitState polls a named context status until it reaches the argument state
+// and its execution completed the argument number of runs.
+func waitState(t *testing.T, s *Supervisor, name string, ctx *fakeContext, state int, runs int) Status {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+
+	for time.Now().Before(deadline) {
+		status, err := s.Status(name)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if status.State == state && ctx.Runs() == runs {
+			return status
+		}
+
+		time.Sleep(time.Millisecond)
+	}
+
+	status, _ := s.Status(name)
+	t.Fatalf("state = %s, runs = %d, want state %d after %d runs", status.String(), ctx.Runs(), state, runs)
+
+	return status
+}
+
+func TestAdd(t *testing.T) {
+	s := NewSupervisor()
+	ctx := &resetContext{}
+
+	if err := s.Add("ta", ctx, Policy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if ctx.snapshots != 1 {
+		t.Errorf("snapshots = %d, want 1", ctx.snapshots)
+	}
+
+	if err := s.Add("ta", ctx, Policy{}); err == nil {
+		t.Error("expected duplicate error")
+	}
+
+	if err := s.Add("invalid", ctx, Policy{Restart: RestartAlways + 1}); err == nil {
+		t.Error("expected invalid policy error")
+	}
+
+	if err := s.Start("missing"); err == nil {
+		t.Error("expected missing context error")
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		errs     []error
+		state    int
+		runs     int
+		restarts int
+		err      bool
+	}{
+		{
+			name:   "never on failure",
+			policy: Policy{Restart: RestartNever},
+			errs:   []error{errFail},
+			state:  Crashed,
+			runs:   1,
+			err:    true,
+		},
+		{
+			name:   "never on exit",
+			policy: Policy{Restart: RestartNever},
+			errs:   []error{nil},
+			state:  Stopped,
+			runs:   1,
+		},
+		{
+			name:     "on-failure restarts failures",
+			policy:   Policy{Restart: RestartOnFailure},
+			errs:     []error{errFail, errFail, nil},
+			state:    Stopped,
+			runs:     3,
+			restarts: 2,
+		},
+		{
+			name:     "always restarts exits",
+			policy:   Policy{Restart: RestartAlways},
+			errs:     []error{nil, errFail, nil},
+			state:    Running,
+			runs:     4,
+			restarts: 3,
+		},
+		{
+			name: "restart limit",
+			policy: Policy{
+				Restart:     RestartAlways,
+				MaxRestarts: 2,
+				Window:      time.Hour,
+			},
+			errs:     []error{errFail, errFail, errFail, errFail},
+			state:    Crashed,
+			runs:     3,
+			restarts: 2,
+			err:      true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSupervisor()
+			ctx := &resetContext{fakeContext{errs: tt.errs}}
+
+			if err := s.Add("ta", ctx, tt.policy); err != nil {
+				t.Fatal(err)
+			}
+
+			if err := s.Start("ta"); err != nil {
+				t.Fatal(err)
+			}
+
+			defer s.Stop("ta")
+
+			status := waitState(t, s, "ta", &ctx.fakeContext, tt.state, tt.runs)
+
+			if status.Restarts != tt.restarts {
+				t.Errorf("restarts = %d, want %d", status.Restarts, tt.restarts)
+			}
+
+			if (status.Err != nil) != tt.err {
+				t.Errorf("err = %v", status.Err)
+			}
+
+			ctx.Lock()
+			resets := ctx.resets
+			ctx.Unlock()
+
+			if resets != tt.restarts {
+				t.Errorf("resets = %d, want %d", resets, tt.restarts)
+			}
+		})
+	}
+}
+
+func TestResetFailure(t *testing.T) {
+	s := NewSupervisor()
+	ctx := &resetContext{fakeContext{errs: []error{errFail}}}
+	ctx.resetErr = errors.New("reset failure")
+
+	if err := s.Add("ta", ctx, Policy{Restart: RestartAlways}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	status := waitState(t, s, "ta", &ctx.fakeContext, Crashed, 1)
+
+	if !errors.Is(status.Err, ctx.resetErr) {
+		t.Errorf("err = %v, want %v", status.Err, ctx.resetErr)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   Policy
+		failures int
+		delay    time.Duration
+	}{
+		{"no backoff", Policy{}, 3, 0},
+		{"first failure", Policy{Backoff: 10 * time.Millisecond}, 1, 10 * time.Millisecond},
+		{"exit", Policy{Backoff: 10 * time.Millisecond}, 0, 10 * time.Millisecond},
+		{"doubled", Policy{Backoff: 10 * time.Millisecond}, 3, 40 * time.Millisecond},
+		{"bounded", Policy{Backoff: 10 * time.Millisecond, MaxBackoff: 25 * time.Millisecond}, 3, 25 * time.Millisecond},
+		{"overflow", Policy{Backoff: time.Second, MaxBackoff: time.Minute}, 1000, time.Minute},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &applet{policy: tt.policy}
+			delay, ok := a.restart(tt.failures)
+
+			if !ok {
+				t.Fatal("unexpected restart limit")
+			}
+
+			if delay != tt.delay {
+				t.Errorf("delay = %v, want %v", delay, tt.delay)
+			}
+		})
+	}
+}
+
+func TestRestartWindow(t *testing.T) {
+	now := time.Now()
+	policy := Policy{MaxRestarts: 2, Window: time.Minute}
+
+	tests := []struct {
+		name     string
+		restarts []time.Time
+		ok       bool
+		recent   int
+	}{
+		{"no restarts", nil, true, 1},
+		{"below limit", []time.Time{now.Add(-time.Second)}, true, 2},
+		{"limit reached", []time.Time{now.Add(-2 * time.Second), now.Add(-time.Second)}, false, 2},
+		{"expired restarts", []time.Time{now.Add(-2 * time.Minute), now.Add(-time.Second)}, true, 2},
+		{"all expired", []time.Time{now.Add(-3 * time.Minute), now.Add(-2 * time.Minute)}, true, 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := &applet{policy: policy, restarts: tt.restarts}
+
+			if _, ok := a.restart(1); ok != tt.ok {
+				t.Fatalf("ok = %v, want %v", ok, tt.ok)
+			}
+
+			if len(a.restarts) != tt.recent {
+				t.Errorf("restarts = %d, want %d", len(a.restarts), tt.recent)
+			}
+		})
+	}
+}
+
+func TestStop(t *testing.T) {
+	s := NewSupervisor()
+	ctx := &fakeContext{}
+
+	if err := s.Add("ta", ctx, Policy{Restart: RestartAlways}); err != nil {
+		t.Fatal(err)
+	}
+
+	// stopping a context which is not started is a no-op
+	if err := s.Stop("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState(t, s, "ta", ctx, Running, 1)
+
+	if err := s.Start("ta"); err == nil {
+		t.Error("expected already started error")
+	}
+
+	if err := s.Stop("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ := s.Status("ta")
+
+	if status.State != Stopped || status.Err != nil || status.Restarts != 0 {
+		t.Errorf("status = %s, err:%v, restarts:%d", status.String(), status.Err, status.Restarts)
+	}
+
+	// a stopped context can be started again
+	if err := s.Start("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState(t, s, "ta", ctx, Running, 2)
+
+	if err := s.Stop("ta"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStopRestarting(t *testing.T) {
+	s := NewSupervisor()
+	ctx := &fakeContext{errs: []error{errFail}}
+
+	if err := s.Add("ta", ctx, Policy{Restart: RestartAlways, Backoff: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState(t, s, "ta", ctx, Restarting, 1)
+
+	done := make(chan error)
+
+	go func() {
+		done <- s.Stop("ta")
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop() blocked during backoff")
+	}
+
+	if status, _ := s.Status("ta"); status.State != Stopped {
+		t.Errorf("state = %s, want stopped", status.String())
+	}
+}
+
+func TestRemove(t *testing.T) {
+	s := NewSupervisor()
+	ctx := &fakeContext{}
+
+	if err := s.Add("ta", ctx, Policy{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Start("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	waitState(t, s, "ta", ctx, Running, 1)
+
+	if err := s.Remove("ta"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Status("ta"); err == nil {
+		t.Error("removed context still present")
+	}
+
+	if err := s.Remove("ta"); err == nil {
+		t.Error("expected missing context error")
+	}
+
+	if len(s.List()) != 0 {
+		t.Errorf("list = %v, want empty", s.List())
+	}
+}
+
+func TestList(t *testing.T) {
+	s := NewSupervisor()
+
+	for _, name := range []string{"c", "a", "b"} {
+		if err := s.Add(name, &fakeContext{}, Policy{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := s.List()
+
+	if len(list) != 3 || list[0].Name != "a" || list[1].Name != "b" || list[2].Name != "c" {
+		t.Errorf("list = %v, want sorted names", list)
+	}
+}
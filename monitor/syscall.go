@@ -11,11 +11,20 @@ import "errors"
 // GoTEE secure monitor calls (syscall.Read(), syscall.Write()) and returns the
 // computed memory offset and transfer size.
 func (ctx *ExecCtx) TransferRegion() (off int, n int, err error) {
-	off = int(ctx.A1()) - int(ctx.Memory.Start())
 	n = int(ctx.A2())
+	off, err = ctx.MemoryRegion(ctx.A1(), n)
+
+	return
+}
+
+// MemoryRegion validates a memory transfer request, for the argument address
+// and size, against the execution context memory and returns the computed
+// memory offset.
+func (ctx *ExecCtx) MemoryRegion(addr uint, n int) (off int, err error) {
+	off = int(addr) - int(ctx.Memory.Start())
 	s := int(ctx.Memory.Size())
 
-	if valid := (off >= 0) && (n <= s) && (off < s-n); !valid {
+	if valid := (off >= 0) && (n >= 0) && (n <= s) && (off < s-n); !valid {
 		err = errors.New("invalid offset")
 	}
 
@@ -24,7 +24,8 @@ func (ctx *ExecCtx) A2() uint {
 }
 
 // Ret sets the return value for GoTEE secure monitor calls updating the
-// relevant execution context registers, including its Shadow if present.
+// relevant execution context registers, including its Shadow and Replicas if
+// present.
 func (ctx *ExecCtx) Ret(val interface{}) {
 	var r0 uint32
 	var r1 uint32
@@ -44,11 +45,23 @@ func (ctx *ExecCtx) Ret(val interface{}) {
 		panic("invalid return type")
 	}
 
-	ctx.R0 = r0
-	ctx.R1 = r1
+	ctx.Return(uint64(r0), uint64(r1))
+}
+
+// Return sets the argument values in the execution context return registers
+// (R0-R3), including its Shadow and Replicas if present.
+func (ctx *ExecCtx) Return(vals ...uint64) {
+	if len(vals) > 4 {
+		panic("invalid return values")
+	}
+
+	ctx.record(InputReturn, vals, 0, nil)
+
+	for _, c := range append([]*ExecCtx{ctx}, ctx.redundant()...) {
+		regs := []*uint32{&c.R0, &c.R1, &c.R2, &c.R3}
 
-	if ctx.Shadow != nil {
-		ctx.Shadow.R0 = r0
-		ctx.Shadow.R1 = r1
+		for i, val := range vals {
+			*regs[i] = uint32(val)
+		}
 	}
 }
@@ -24,7 +24,8 @@ func (ctx *ExecCtx) A2() uint {
 }
 
 // Ret sets the return value for GoTEE secure monitor calls updating the
-// relevant execution context registers, including its Shadow if present.
+// relevant execution context registers, including its Shadow and Replicas if
+// present.
 func (ctx *ExecCtx) Ret(val interface{}) {
 	var x10 uint64
 
@@ -41,9 +42,23 @@ func (ctx *ExecCtx) Ret(val interface{}) {
 		panic("invalid return type")
 	}
 
-	ctx.X10 = x10
+	ctx.Return(x10)
+}
+
+// Return sets the argument values in the execution context return registers
+// (A0-A7), including its Shadow and Replicas if present.
+func (ctx *ExecCtx) Return(vals ...uint64) {
+	if len(vals) > 8 {
+		panic("invalid return values")
+	}
+
+	ctx.record(InputReturn, vals, 0, nil)
+
+	for _, c := range append([]*ExecCtx{ctx}, ctx.redundant()...) {
+		regs := []*uint64{&c.X10, &c.X11, &c.X12, &c.X13, &c.X14, &c.X15, &c.X16, &c.X17}
 
-	if ctx.Shadow != nil {
-		ctx.Shadow.X10 = x10
+		for i, val := range vals {
+			*regs[i] = val
+		}
 	}
 }
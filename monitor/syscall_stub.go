@@ -21,5 +21,10 @@ func (ctx *ExecCtx) A1() uint
 func (ctx *ExecCtx) A2() uint
 
 // Ret sets the return value for GoTEE secure monitor calls updating the
-// relevant execution context registers, including its Shadow if present.
+// relevant execution context registers, including its Shadow and Replicas if
+// present.
 func (ctx *ExecCtx) Ret(val interface{})
+
+// Return sets the argument values in the execution context return registers
+// (ARM: R0-R3, RISC-V: A0-A7), including its Shadow and Replicas if present.
+func (ctx *ExecCtx) Return(vals ...uint64)
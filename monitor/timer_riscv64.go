@@ -0,0 +1,118 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"math/bits"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"github.com/usbarmory/tamago/soc/sifive/clint"
+	"github.com/usbarmory/tamago/soc/sifive/fu540"
+)
+
+// CLINT registers
+const (
+	MTIMECMP = 0x4000
+)
+
+// CLINT represents the Core-Local Interruptor instance holding the hart
+// timers.
+var CLINT *clint.CLINT = fu540.CLINT
+
+// defined in timer_riscv64.s
+func write64(addr uint64, val uint64)
+
+// read64 reads a 64-bit memory mapped register.
+func read64(addr uint64) uint64 {
+	var ptr unsafe.Pointer
+
+	ptr = unsafe.Add(ptr, addr)
+	return atomic.LoadUint64((*uint64)(ptr))
+}
+
+// Timecmp returns the machine timer compare register (mtimecmp) of the
+// argument hart.
+func Timecmp(hart uint64) uint64 {
+	return read64(CLINT.Base + MTIMECMP + 8*hart)
+}
+
+// SetTimecmp programs the machine timer compare register (mtimecmp) of the
+// argument hart, a machine timer interrupt is pending once the CLINT mtime
+// register is greater or equal to it.
+func SetTimecmp(hart uint64, val uint64) {
+	write64(CLINT.Base+MTIMECMP+8*hart, val)
+}
+
+// preemption returns the earliest of the execution context RunContext()
+// deadline and hart group Quantum expiration, if any.
+func (ctx *ExecCtx) preemption() (t time.Time) {
+	t = ctx.deadline
+
+	if ctx.Harts == nil || ctx.Harts.Quantum <= 0 {
+		return
+	}
+
+	if q := time.Now().Add(ctx.Harts.Quantum); t.IsZero() || q.Before(t) {
+		t = q
+	}
+
+	return
+}
+
+// armPreemption programs the current hart timer to preempt the execution
+// context at its RunContext() deadline or hart group Quantum, unless an
+// earlier machine timer interrupt is already enabled, it returns the function
+// which restores the previous timer state.
+//
+// Preemption is not enforced on redundant execution contexts (see Shadow,
+// Replicas) as it would break their synchronization.
+func (ctx *ExecCtx) armPreemption() (restore func()) {
+	restore = func() {}
+
+	at := ctx.preemption()
+
+	if at.IsZero() || ctx.Shadow != nil || len(ctx.Replicas) > 0 {
+		return
+	}
+
+	var ticks uint64
+
+	if d := time.Until(at); d > 0 {
+		hi, lo := bits.Mul64(uint64(d), CLINT.RTCCLK)
+
+		if hi >= 1e9 {
+			return
+		}
+
+		// round up to never preempt early
+		ticks, _ = bits.Div64(hi, lo, 1e9)
+		ticks += 1
+	}
+
+	hart := HartID()
+	mtime := CLINT.Mtime()
+	timecmp := Timecmp(hart)
+	mie := read_mie()
+
+	cmp := mtime + ticks
+
+	if cmp < mtime || (mie&(1<<MachineTimerInterrupt) != 0 && timecmp <= cmp) {
+		return
+	}
+
+	SetTimecmp(hart, cmp)
+	set_mie(1 << MachineTimerInterrupt)
+
+	return func() {
+		SetTimecmp(hart, timecmp)
+
+		if mie&(1<<MachineTimerInterrupt) == 0 {
+			clear_mie(1 << MachineTimerInterrupt)
+		}
+	}
+}
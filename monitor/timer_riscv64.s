@@ -0,0 +1,15 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+// func write64(addr uint64, val uint64)
+TEXT ·write64(SB),NOSPLIT,$0-16
+	MOV	addr+0(FP), T0
+	MOV	val+8(FP), T1
+
+	MOV	T1, (T0)
+
+	RET
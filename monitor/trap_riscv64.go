@@ -0,0 +1,103 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package monitor
+
+import (
+	"github.com/usbarmory/tamago/riscv64"
+)
+
+// RISC-V interrupt codes
+// (Table 3.6 - Volume II: RISC-V Privileged Architectures V20211203).
+const (
+	SupervisorSoftwareInterrupt = 1
+	MachineSoftwareInterrupt    = 3
+	SupervisorTimerInterrupt    = 5
+	MachineTimerInterrupt       = 7
+	SupervisorExternalInterrupt = 9
+	MachineExternalInterrupt    = 11
+)
+
+// defined in trap_riscv64.s
+func write_medeleg(val uint64)
+func write_mideleg(val uint64)
+func read_mhartid() uint64
+func read_mie() uint64
+func set_mie(val uint64)
+func clear_mie(val uint64)
+func read_mip() uint64
+func set_mip(val uint64)
+func clear_mip(val uint64)
+
+// Delegation represents the traps which are delegated to Supervisor mode,
+// rather than being handled by the monitor
+// (3.1.8 Machine Trap Delegation Registers (medeleg and mideleg)).
+type Delegation struct {
+	// Exceptions is the bitmask of delegated exception codes (medeleg).
+	Exceptions uint64
+	// Interrupts is the bitmask of delegated interrupt codes (mideleg).
+	Interrupts uint64
+}
+
+// Delegation policies
+var (
+	// TrustedDelegation is the default policy for trusted applets, all
+	// traps are handled by the monitor.
+	TrustedDelegation = Delegation{}
+
+	// KernelDelegation is the default policy for non-secure Supervisor
+	// mode kernels (e.g. Linux), which handle their own page faults,
+	// breakpoints, User mode system calls as well as Supervisor
+	// interrupts.
+	KernelDelegation = Delegation{
+		Exceptions: 1<<riscv64.InstructionAddressMisaligned |
+			1<<riscv64.Breakpoint |
+			1<<riscv64.EnvironmentCallFromU |
+			1<<riscv64.InstructionPageFault |
+			1<<riscv64.LoadPageFault |
+			1<<riscv64.StorePageFault,
+		Interrupts: 1<<SupervisorSoftwareInterrupt |
+			1<<SupervisorTimerInterrupt |
+			1<<SupervisorExternalInterrupt,
+	}
+)
+
+// apply configures the hart trap delegation registers.
+func (d *Delegation) apply() {
+	write_medeleg(d.Exceptions)
+	write_mideleg(d.Interrupts)
+}
+
+// HartID returns the current hart identifier (mhartid).
+func HartID() uint64 {
+	return read_mhartid()
+}
+
+// EnableInterrupt enables or disables the argument interrupt code in the
+// current hart Machine Interrupt Enable register (mie).
+//
+// Machine level interrupts, which are not delegated, are taken by the monitor
+// while an execution context is running and passed to its Handler().
+func EnableInterrupt(code int, enable bool) {
+	if enable {
+		set_mie(1 << code)
+	} else {
+		clear_mie(1 << code)
+	}
+}
+
+// SetPending sets or clears the argument interrupt code in the current hart
+// Machine Interrupt Pending register (mip), only Supervisor interrupts are
+// writable (3.1.9 Machine Interrupt Registers (mip and mie)).
+//
+// It allows injection of Supervisor interrupts in an execution context, which
+// receives them on its next Schedule() if delegated.
+func SetPending(code int, pending bool) {
+	if pending {
+		set_mip(1 << code)
+	} else {
+		clear_mip(1 << code)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+#include "textflag.h"
+
+#include "go_asm_riscv64.h"
+
+// func write_medeleg(val uint64)
+TEXT ·write_medeleg(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRW(t0, medeleg)
+
+	RET
+
+// func write_mideleg(val uint64)
+TEXT ·write_mideleg(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRW(t0, mideleg)
+
+	RET
+
+// func read_mhartid() uint64
+TEXT ·read_mhartid(SB),NOSPLIT,$0-8
+	CSRR(mhartid, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func read_mie() uint64
+TEXT ·read_mie(SB),NOSPLIT,$0-8
+	CSRR(mie, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func set_mie(val uint64)
+TEXT ·set_mie(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRS(t0, mie)
+
+	RET
+
+// func clear_mie(val uint64)
+TEXT ·clear_mie(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRC(t0, mie)
+
+	RET
+
+// func read_mip() uint64
+TEXT ·read_mip(SB),NOSPLIT,$0-8
+	CSRR(mip, t0)
+	MOV	T0, ret+0(FP)
+
+	RET
+
+// func set_mip(val uint64)
+TEXT ·set_mip(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRS(t0, mip)
+
+	RET
+
+// func clear_mip(val uint64)
+TEXT ·clear_mip(SB),NOSPLIT,$0-8
+	MOV	val+0(FP), T0
+	CSRC(t0, mip)
+
+	RET
@@ -0,0 +1,499 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package optee
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// OP-TEE message commands
+const (
+	OPTEE_MSG_CMD_OPEN_SESSION     = 0
+	OPTEE_MSG_CMD_INVOKE_COMMAND   = 1
+	OPTEE_MSG_CMD_CLOSE_SESSION    = 2
+	OPTEE_MSG_CMD_CANCEL           = 3
+	OPTEE_MSG_CMD_REGISTER_SHM     = 4
+	OPTEE_MSG_CMD_UNREGISTER_SHM   = 5
+	OPTEE_MSG_CMD_DO_BOTTOM_HALF   = 6
+	OPTEE_MSG_CMD_STOP_ASYNC_NOTIF = 7
+)
+
+// OP-TEE message parameter attributes
+const (
+	OPTEE_MSG_ATTR_TYPE_NONE         = 0x0
+	OPTEE_MSG_ATTR_TYPE_VALUE_INPUT  = 0x1
+	OPTEE_MSG_ATTR_TYPE_VALUE_OUTPUT = 0x2
+	OPTEE_MSG_ATTR_TYPE_VALUE_INOUT  = 0x3
+	OPTEE_MSG_ATTR_TYPE_RMEM_INPUT   = 0x5
+	OPTEE_MSG_ATTR_TYPE_RMEM_OUTPUT  = 0x6
+	OPTEE_MSG_ATTR_TYPE_RMEM_INOUT   = 0x7
+	OPTEE_MSG_ATTR_TYPE_TMEM_INPUT   = 0x9
+	OPTEE_MSG_ATTR_TYPE_TMEM_OUTPUT  = 0xa
+	OPTEE_MSG_ATTR_TYPE_TMEM_INOUT   = 0xb
+	OPTEE_MSG_ATTR_TYPE_MASK         = 0xff
+	OPTEE_MSG_ATTR_META              = 1 << 8
+	OPTEE_MSG_ATTR_NONCONTIG         = 1 << 9
+)
+
+// GlobalPlatform TEE Client API return codes
+const (
+	TEEC_SUCCESS               = 0x00000000
+	TEEC_ERROR_GENERIC         = 0xffff0000
+	TEEC_ERROR_ACCESS_DENIED   = 0xffff0001
+	TEEC_ERROR_CANCEL          = 0xffff0002
+	TEEC_ERROR_BAD_FORMAT      = 0xffff0005
+	TEEC_ERROR_BAD_PARAMETERS  = 0xffff0006
+	TEEC_ERROR_ITEM_NOT_FOUND  = 0xffff0008
+	TEEC_ERROR_NOT_IMPLEMENTED = 0xffff0009
+	TEEC_ERROR_NOT_SUPPORTED   = 0xffff000a
+	TEEC_ERROR_OUT_OF_MEMORY   = 0xffff000c
+	TEEC_ERROR_BUSY            = 0xffff000d
+	TEEC_ERROR_COMMUNICATION   = 0xffff000e
+	TEEC_ERROR_SHORT_BUFFER    = 0xffff0010
+	TEEC_ERROR_TARGET_DEAD     = 0xffff3024
+)
+
+// GlobalPlatform TEE Client API return code origins
+const (
+	TEEC_ORIGIN_API         = 1
+	TEEC_ORIGIN_COMMS       = 2
+	TEEC_ORIGIN_TEE         = 3
+	TEEC_ORIGIN_TRUSTED_APP = 4
+)
+
+const (
+	// maximum number of message parameters
+	maxParams = 16
+	// shared memory page size
+	pageSize = 4096
+	// page list entries per page, the last entry links to the next page
+	pageListEntries = pageSize/8 - 1
+)
+
+// UUID represents a Trusted Application UUID, in its canonical octet order.
+type UUID [16]byte
+
+// String returns the canonical string form of the UUID.
+func (u UUID) String() string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:16])
+}
+
+// Param represents a Trusted Application operation parameter.
+type Param struct {
+	// Type is the parameter type (OPTEE_MSG_ATTR_TYPE_*)
+	Type int
+
+	// A, B, C hold value parameters
+	A uint64
+	B uint64
+	C uint64
+
+	// Buffer holds memory reference contents, its length matches the
+	// Normal World buffer size.
+	Buffer []byte
+	// Size is the memory reference size, output parameters can report a
+	// required size larger than Buffer along with TEEC_ERROR_SHORT_BUFFER.
+	Size uint64
+
+	// Normal World buffer
+	region region
+}
+
+// Value returns whether the parameter is a value.
+func (p *Param) Value() bool {
+	return p.Type >= OPTEE_MSG_ATTR_TYPE_VALUE_INPUT && p.Type <= OPTEE_MSG_ATTR_TYPE_VALUE_INOUT
+}
+
+// Memref returns whether the parameter is a memory reference.
+func (p *Param) Memref() bool {
+	return p.Type >= OPTEE_MSG_ATTR_TYPE_RMEM_INPUT && p.Type <= OPTEE_MSG_ATTR_TYPE_TMEM_INOUT && p.Type != 0x8
+}
+
+// Output returns whether the parameter is returned to the Normal World.
+func (p *Param) Output() bool {
+	return p.Type&0b10 != 0
+}
+
+// TrustedApplication represents a Trusted Application reachable through the
+// OP-TEE message protocol, the Trusted OS is responsible for relaying its
+// operations to the relevant Trusted Applet.
+//
+// All functions receive the operation parameters, which can be updated in
+// place for output ones, and return a TEEC_* return code.
+type TrustedApplication interface {
+	// OpenSession opens a session for the argument client UUID and login
+	// method.
+	OpenSession(session uint32, client UUID, login uint32, params []*Param) uint32
+	// InvokeCommand invokes a command within an open session.
+	InvokeCommand(session uint32, cmd uint32, params []*Param) uint32
+	// CloseSession closes an open session.
+	CloseSession(session uint32)
+}
+
+type session struct {
+	ctx *monitor.ExecCtx
+	ta  TrustedApplication
+}
+
+// msgArg represents the OP-TEE message argument header (struct optee_msg_arg).
+type msgArg struct {
+	Cmd       uint32
+	Func      uint32
+	Session   uint32
+	CancelID  uint32
+	Pad       uint32
+	Ret       uint32
+	RetOrigin uint32
+	NumParams uint32
+}
+
+// msgParam represents an OP-TEE message parameter (struct optee_msg_param).
+type msgParam struct {
+	Attr uint64
+	U    [3]uint64
+}
+
+// segment represents a physically contiguous Normal World buffer.
+type segment struct {
+	addr uint64
+	size int
+}
+
+// region represents a Normal World buffer, as a list of physically
+// contiguous segments.
+type region []segment
+
+// read returns the buffer contents from execution context memory.
+func (r region) read(ctx *monitor.ExecCtx) (buf []byte, err error) {
+	for _, s := range r {
+		off, err := ctx.MemoryRegion(uint(s.addr), s.size)
+
+		if err != nil {
+			return nil, err
+		}
+
+		b := make([]byte, s.size)
+		ctx.Memory.Read(ctx.Memory.Start(), off, b)
+		buf = append(buf, b...)
+	}
+
+	return
+}
+
+// write returns the buffer contents to execution context memory.
+func (r region) write(ctx *monitor.ExecCtx, buf []byte) (err error) {
+	for _, s := range r {
+		if len(buf) == 0 {
+			break
+		}
+
+		off, err := ctx.MemoryRegion(uint(s.addr), s.size)
+
+		if err != nil {
+			return err
+		}
+
+		n := min(s.size, len(buf))
+		ctx.Poke(off, buf[:n])
+		buf = buf[n:]
+	}
+
+	return
+}
+
+// slice returns a sub-region for the argument offset and size.
+func (r region) slice(off uint64, size uint64) (sub region, err error) {
+	for _, s := range r {
+		if size == 0 {
+			break
+		}
+
+		if off >= uint64(s.size) {
+			off -= uint64(s.size)
+			continue
+		}
+
+		n := min(uint64(s.size)-off, size)
+		sub = append(sub, segment{s.addr + off, int(n)})
+
+		off = 0
+		size -= n
+	}
+
+	if size != 0 {
+		return nil, errors.New("invalid shared memory range")
+	}
+
+	return
+}
+
+// pages returns the region described by a non-contiguous page list, the page
+// list address low bits hold the buffer offset within its first page
+// (OPTEE_MSG_ATTR_NONCONTIG).
+func pages(ctx *monitor.ExecCtx, list uint64, size uint64) (r region, err error) {
+	off := list % pageSize
+	list -= off
+
+	n := (off + size + pageSize - 1) / pageSize
+	entries := make([]uint64, 0, n)
+
+	for uint64(len(entries)) < n {
+		buf, err := region{{list, pageSize}}.read(ctx)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for i := 0; i < pageListEntries && uint64(len(entries)) < n; i++ {
+			entries = append(entries, binary.LittleEndian.Uint64(buf[i*8:]))
+		}
+
+		list = binary.LittleEndian.Uint64(buf[pageListEntries*8:])
+	}
+
+	for _, page := range entries {
+		r = append(r, segment{page, pageSize})
+	}
+
+	return r.slice(off, size)
+}
+
+// param converts a message parameter to a Trusted Application one.
+func (s *Service) param(ctx *monitor.ExecCtx, mp *msgParam) (p *Param, err error) {
+	p = &Param{
+		Type: int(mp.Attr & OPTEE_MSG_ATTR_TYPE_MASK),
+	}
+
+	switch {
+	case p.Type == OPTEE_MSG_ATTR_TYPE_NONE:
+		return
+	case p.Value():
+		p.A, p.B, p.C = mp.U[0], mp.U[1], mp.U[2]
+		return
+	case !p.Memref():
+		return nil, fmt.Errorf("invalid parameter type %#x", p.Type)
+	}
+
+	p.Size = mp.U[1]
+
+	switch {
+	case p.Type >= OPTEE_MSG_ATTR_TYPE_TMEM_INPUT && mp.U[0] == 0:
+		// null memory reference
+		return
+	case p.Type >= OPTEE_MSG_ATTR_TYPE_TMEM_INPUT && mp.Attr&OPTEE_MSG_ATTR_NONCONTIG != 0:
+		p.region, err = pages(ctx, mp.U[0], p.Size)
+	case p.Type >= OPTEE_MSG_ATTR_TYPE_TMEM_INPUT:
+		p.region = region{{mp.U[0], int(p.Size)}}
+	default:
+		shm, ok := s.shm[mp.U[2]]
+
+		if !ok {
+			return nil, errors.New("invalid shared memory reference")
+		}
+
+		p.region, err = shm.slice(mp.U[0], p.Size)
+	}
+
+	if err != nil {
+		return
+	}
+
+	if p.Buffer, err = p.region.read(ctx); err != nil {
+		return
+	}
+
+	if !p.Output() || p.Type&0b01 != 0 {
+		return
+	}
+
+	// output only buffers are not disclosed to the Trusted Application
+	clear(p.Buffer)
+
+	return
+}
+
+// update returns a Trusted Application parameter to its message parameter.
+func (s *Service) update(ctx *monitor.ExecCtx, p *Param, mp *msgParam) (err error) {
+	if !p.Output() {
+		return
+	}
+
+	switch {
+	case p.Value():
+		mp.U[0], mp.U[1], mp.U[2] = p.A, p.B, p.C
+	case p.Memref():
+		mp.U[1] = p.Size
+
+		if p.Size <= uint64(len(p.Buffer)) {
+			err = p.region.write(ctx, p.Buffer[:p.Size])
+		}
+	}
+
+	return
+}
+
+// handle serves the message argument structure at the argument address.
+func (s *Service) handle(ctx *monitor.ExecCtx, addr uint64) (err error) {
+	var arg msgArg
+
+	hdr := region{{addr, binary.Size(arg)}}
+	buf, err := hdr.read(ctx)
+
+	if err != nil {
+		return
+	}
+
+	binary.Read(bytes.NewReader(buf), binary.LittleEndian, &arg)
+
+	if arg.NumParams > maxParams {
+		return errors.New("invalid number of parameters")
+	}
+
+	mps := make([]msgParam, arg.NumParams)
+	all := region{{addr, binary.Size(arg) + binary.Size(mps)}}
+
+	if buf, err = all.read(ctx); err != nil {
+		return
+	}
+
+	binary.Read(bytes.NewReader(buf[binary.Size(arg):]), binary.LittleEndian, mps)
+
+	s.Lock()
+	arg.Ret, arg.RetOrigin = s.command(ctx, &arg, mps)
+	s.Unlock()
+
+	out := new(bytes.Buffer)
+
+	binary.Write(out, binary.LittleEndian, &arg)
+	binary.Write(out, binary.LittleEndian, mps)
+
+	return all.write(ctx, out.Bytes())
+}
+
+// command executes a message command, returning its result and origin.
+func (s *Service) command(ctx *monitor.ExecCtx, arg *msgArg, mps []msgParam) (ret uint32, origin uint32) {
+	var params []*Param
+
+	switch arg.Cmd {
+	case OPTEE_MSG_CMD_OPEN_SESSION, OPTEE_MSG_CMD_INVOKE_COMMAND:
+		first := 0
+
+		if arg.Cmd == OPTEE_MSG_CMD_OPEN_SESSION {
+			// TA and client UUID meta parameters
+			first = 2
+		}
+
+		if len(mps) < first {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+
+		for i := first; i < len(mps); i++ {
+			p, err := s.param(ctx, &mps[i])
+
+			if err != nil {
+				return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+			}
+
+			params = append(params, p)
+		}
+
+		defer func() {
+			for i, p := range params {
+				if err := s.update(ctx, p, &mps[first+i]); err != nil {
+					ret, origin = TEEC_ERROR_COMMUNICATION, TEEC_ORIGIN_TEE
+				}
+			}
+		}()
+	}
+
+	switch arg.Cmd {
+	case OPTEE_MSG_CMD_OPEN_SESSION:
+		return s.openSession(ctx, arg, mps, params)
+	case OPTEE_MSG_CMD_INVOKE_COMMAND:
+		sess, ok := s.sessions[arg.Session]
+
+		if !ok || sess.ctx != ctx {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+
+		return sess.ta.InvokeCommand(arg.Session, arg.Func, params), TEEC_ORIGIN_TRUSTED_APP
+	case OPTEE_MSG_CMD_CLOSE_SESSION:
+		sess, ok := s.sessions[arg.Session]
+
+		if !ok || sess.ctx != ctx {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+
+		sess.ta.CloseSession(arg.Session)
+		delete(s.sessions, arg.Session)
+	case OPTEE_MSG_CMD_CANCEL:
+		// requests are served synchronously, there is nothing to cancel
+	case OPTEE_MSG_CMD_REGISTER_SHM:
+		if len(mps) != 1 || mps[0].Attr&OPTEE_MSG_ATTR_NONCONTIG == 0 {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+
+		shm, err := pages(ctx, mps[0].U[0], mps[0].U[1])
+
+		if err != nil {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+
+		s.shm[mps[0].U[2]] = shm
+	case OPTEE_MSG_CMD_UNREGISTER_SHM:
+		if len(mps) != 1 {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+
+		delete(s.shm, mps[0].U[2])
+	default:
+		return TEEC_ERROR_NOT_IMPLEMENTED, TEEC_ORIGIN_TEE
+	}
+
+	return TEEC_SUCCESS, TEEC_ORIGIN_TEE
+}
+
+// uuid returns the UUID held in a meta value parameter.
+func uuid(mp *msgParam) (u UUID) {
+	binary.LittleEndian.PutUint64(u[0:8], mp.U[0])
+	binary.LittleEndian.PutUint64(u[8:16], mp.U[1])
+	return
+}
+
+func (s *Service) openSession(ctx *monitor.ExecCtx, arg *msgArg, mps []msgParam, params []*Param) (ret uint32, origin uint32) {
+	for i := 0; i < 2; i++ {
+		if mps[i].Attr != OPTEE_MSG_ATTR_TYPE_VALUE_INPUT|OPTEE_MSG_ATTR_META {
+			return TEEC_ERROR_BAD_PARAMETERS, TEEC_ORIGIN_TEE
+		}
+	}
+
+	ta, ok := s.applications[uuid(&mps[0])]
+
+	if !ok {
+		return TEEC_ERROR_ITEM_NOT_FOUND, TEEC_ORIGIN_TEE
+	}
+
+	s.session += 1
+	id := s.session
+
+	if ret = ta.OpenSession(id, uuid(&mps[1]), uint32(mps[1].U[2]), params); ret != TEEC_SUCCESS {
+		return ret, TEEC_ORIGIN_TRUSTED_APP
+	}
+
+	s.sessions[id] = &session{
+		ctx: ctx,
+		ta:  ta,
+	}
+
+	arg.Session = id
+
+	return TEEC_SUCCESS, TEEC_ORIGIN_TRUSTED_APP
+}
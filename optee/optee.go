@@ -0,0 +1,218 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package optee implements the OP-TEE secure monitor call ABI and message
+// protocol, to let unmodified Normal World OP-TEE drivers (e.g. Linux
+// drivers/tee/optee) and GlobalPlatform TEE Client API applications reach
+// Trusted Applications supervised by a GoTEE Trusted OS.
+//
+// The service is registered on the monitor SMC Calling Convention dispatcher
+// (see monitor.SMC) and maps sessions onto Trusted Applications identified by
+// UUID (see TrustedApplication).
+//
+// Only dynamic shared memory is supported, all messages and buffers are
+// addressed physically and must be within the calling execution context
+// memory. Requests are served synchronously, therefore a single OP-TEE thread
+// is reported and no RPC is issued towards the Normal World.
+//
+// This package implements the following reference specifications:
+//   - optee_smc.h - OP-TEE SMC interface (OP-TEE OS 3.x)
+//   - optee_msg.h - OP-TEE message protocol (OP-TEE OS 3.x)
+//
+// This package is only meant to be used with `GOOS=tamago GOARCH=arm` as
+// supported by the TamaGo framework for bare metal Go, see
+// https://github.com/usbarmory/tamago.
+package optee
+
+import (
+	"sync"
+
+	"github.com/usbarmory/GoTEE/monitor"
+	"github.com/usbarmory/GoTEE/smccc"
+)
+
+// OP-TEE API revision
+const (
+	OPTEE_MSG_REVISION_MAJOR = 2
+	OPTEE_MSG_REVISION_MINOR = 0
+)
+
+// OP-TEE SMC function IDs
+const (
+	OPTEE_SMC_CALLS_COUNT           = 0xbf00ff00
+	OPTEE_SMC_CALLS_UID             = 0xbf00ff01
+	OPTEE_SMC_CALLS_REVISION        = 0xbf00ff03
+	OPTEE_SMC_GET_OS_UUID           = 0xb2000000
+	OPTEE_SMC_GET_OS_REVISION       = 0xb2000001
+	OPTEE_SMC_RETURN_FROM_RPC       = 0x32000003
+	OPTEE_SMC_CALL_WITH_ARG         = 0x32000004
+	OPTEE_SMC_GET_SHM_CONFIG        = 0xb2000007
+	OPTEE_SMC_EXCHANGE_CAPABILITIES = 0xb2000009
+	OPTEE_SMC_DISABLE_SHM_CACHE     = 0xb200000a
+	OPTEE_SMC_ENABLE_SHM_CACHE      = 0xb200000b
+	OPTEE_SMC_GET_THREAD_COUNT      = 0xb200000f
+)
+
+// OP-TEE SMC return codes
+const (
+	OPTEE_SMC_RETURN_OK               = 0x0
+	OPTEE_SMC_RETURN_ETHREAD_LIMIT    = 0xffff0000
+	OPTEE_SMC_RETURN_EBUSY            = 0xffff0001
+	OPTEE_SMC_RETURN_ERESUME          = 0xffff0002
+	OPTEE_SMC_RETURN_EBADADDR         = 0xffff0003
+	OPTEE_SMC_RETURN_EBADCMD          = 0xffff0004
+	OPTEE_SMC_RETURN_ENOMEM           = 0xffff0005
+	OPTEE_SMC_RETURN_ENOTAVAIL        = 0xffff0006
+	OPTEE_SMC_RETURN_UNKNOWN_FUNCTION = 0xffffffff
+)
+
+// OP-TEE secure world capabilities
+const (
+	OPTEE_SMC_SEC_CAP_HAVE_RESERVED_SHM = 1 << 0
+	OPTEE_SMC_SEC_CAP_UNREGISTERED_SHM  = 1 << 1
+	OPTEE_SMC_SEC_CAP_DYNAMIC_SHM       = 1 << 2
+	OPTEE_SMC_SEC_CAP_MEMREF_NULL       = 1 << 4
+)
+
+// OP-TEE UUIDs, encoded as four 32-bit words.
+var (
+	// OP-TEE message protocol UID
+	apiUID = []int64{0x384fb3e0, 0xe7f811e3, 0xaf630002, 0xa5d5c51b}
+	// OP-TEE OS UUID
+	osUUID = []int64{0x486178e0, 0xe7f811e3, 0xbc5e0002, 0xa5d5c51b}
+)
+
+// Service represents an OP-TEE compatible Trusted OS service, serving
+// Normal World requests on Trusted Applications.
+type Service struct {
+	sync.Mutex
+
+	// Revision is the Trusted OS revision reported to the Normal World.
+	Revision [2]int64
+
+	applications map[UUID]TrustedApplication
+	sessions     map[uint32]*session
+	shm          map[uint64]region
+
+	// last allocated session ID
+	session uint32
+}
+
+// NewService returns an OP-TEE service without Trusted Applications.
+func NewService() *Service {
+	return &Service{
+		applications: make(map[UUID]TrustedApplication),
+		sessions:     make(map[uint32]*session),
+		shm:          make(map[uint64]region),
+	}
+}
+
+// Add registers a Trusted Application, identified by the argument UUID.
+func (s *Service) Add(uuid UUID, ta TrustedApplication) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.applications[uuid] = ta
+}
+
+// Register adds all OP-TEE SMC functions to the argument dispatcher.
+func (s *Service) Register(d *smccc.Dispatcher) (err error) {
+	functions := map[smccc.FunctionID]smccc.Handler{
+		OPTEE_SMC_CALLS_COUNT:           s.callsCount,
+		OPTEE_SMC_CALLS_UID:             s.callsUID,
+		OPTEE_SMC_CALLS_REVISION:        s.callsRevision,
+		OPTEE_SMC_GET_OS_UUID:           s.osUUID,
+		OPTEE_SMC_GET_OS_REVISION:       s.osRevision,
+		OPTEE_SMC_RETURN_FROM_RPC:       s.returnFromRPC,
+		OPTEE_SMC_CALL_WITH_ARG:         s.callWithArg,
+		OPTEE_SMC_GET_SHM_CONFIG:        s.shmConfig,
+		OPTEE_SMC_EXCHANGE_CAPABILITIES: s.exchangeCapabilities,
+		OPTEE_SMC_DISABLE_SHM_CACHE:     s.disableSHMCache,
+		OPTEE_SMC_ENABLE_SHM_CACHE:      s.enableSHMCache,
+		OPTEE_SMC_GET_THREAD_COUNT:      s.threadCount,
+	}
+
+	for id, h := range functions {
+		if err = d.Register(id, h); err != nil {
+			return
+		}
+	}
+
+	return
+}
+
+func (s *Service) callsCount(call *smccc.Call) error {
+	call.Return(12)
+	return nil
+}
+
+func (s *Service) callsUID(call *smccc.Call) error {
+	call.Return(apiUID...)
+	return nil
+}
+
+func (s *Service) callsRevision(call *smccc.Call) error {
+	call.Return(OPTEE_MSG_REVISION_MAJOR, OPTEE_MSG_REVISION_MINOR)
+	return nil
+}
+
+func (s *Service) osUUID(call *smccc.Call) error {
+	call.Return(osUUID...)
+	return nil
+}
+
+func (s *Service) osRevision(call *smccc.Call) error {
+	call.Return(s.Revision[0], s.Revision[1])
+	return nil
+}
+
+// returnFromRPC is never expected as no RPC is issued to the Normal World.
+func (s *Service) returnFromRPC(call *smccc.Call) error {
+	call.Return(OPTEE_SMC_RETURN_EBADCMD)
+	return nil
+}
+
+func (s *Service) shmConfig(call *smccc.Call) error {
+	// no reserved shared memory
+	call.Return(OPTEE_SMC_RETURN_ENOTAVAIL)
+	return nil
+}
+
+func (s *Service) exchangeCapabilities(call *smccc.Call) error {
+	call.Return(OPTEE_SMC_RETURN_OK, OPTEE_SMC_SEC_CAP_DYNAMIC_SHM|OPTEE_SMC_SEC_CAP_MEMREF_NULL)
+	return nil
+}
+
+func (s *Service) disableSHMCache(call *smccc.Call) error {
+	// no cached shared memory
+	call.Return(OPTEE_SMC_RETURN_ENOTAVAIL)
+	return nil
+}
+
+func (s *Service) enableSHMCache(call *smccc.Call) error {
+	call.Return(OPTEE_SMC_RETURN_OK)
+	return nil
+}
+
+func (s *Service) threadCount(call *smccc.Call) error {
+	call.Return(OPTEE_SMC_RETURN_OK, 1)
+	return nil
+}
+
+// callWithArg serves a message argument structure, at the physical address
+// held in the second (upper 32 bits) and third (lower 32 bits) registers.
+func (s *Service) callWithArg(call *smccc.Call) error {
+	ctx := call.Context.(*monitor.ExecCtx)
+	addr := (call.Regs[1]&0xffffffff)<<32 | call.Regs[2]&0xffffffff
+
+	if err := s.handle(ctx, addr); err != nil {
+		call.Return(OPTEE_SMC_RETURN_EBADADDR)
+		return nil
+	}
+
+	call.Return(OPTEE_SMC_RETURN_OK)
+
+	return nil
+}
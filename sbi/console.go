@@ -0,0 +1,123 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"errors"
+	"io"
+
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// Debug Console Extension Function IDs (FIDs)
+const (
+	EXT_DBCN_CONSOLE_WRITE = iota
+	EXT_DBCN_CONSOLE_READ
+	EXT_DBCN_CONSOLE_WRITE_BYTE
+)
+
+// Console, if not nil, represents the monitor side of the execution context
+// console, it enables the Debug Console and legacy console extensions.
+//
+// Reads must not block, returning no data when no input is available.
+var Console io.ReadWriter
+
+// buffer returns the execution context memory offset for a console transfer,
+// the upper XLEN bits of the guest physical address must be zero
+// (12.1 Function: Console Write (FID #0), RISC-V Supervisor Binary Interface
+// Specification v2.0).
+func buffer(ctx *monitor.ExecCtx) (off int, n int, err error) {
+	if ctx.X12 != 0 {
+		return 0, 0, errors.New("invalid address")
+	}
+
+	n = int(ctx.X10)
+	off, err = ctx.MemoryRegion(uint(ctx.X11), n)
+
+	return
+}
+
+// dbcnHandler implements the Debug Console Extension
+// (Chapter 12. Debug Console Extension (EID #0x4442434E "DBCN"), RISC-V
+// Supervisor Binary Interface Specification v2.0).
+func dbcnHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	if Console == nil {
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+		return
+	}
+
+	switch ctx.X16 {
+	case EXT_DBCN_CONSOLE_WRITE:
+		off, n, err := buffer(ctx)
+
+		if err != nil {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		buf := make([]byte, n)
+		ctx.Memory.Read(ctx.Memory.Start(), off, buf)
+
+		n, err = Console.Write(buf)
+		ret.Value = int64(n)
+
+		if err != nil {
+			ret.Error = SBI_ERR_FAILED
+		}
+	case EXT_DBCN_CONSOLE_READ:
+		off, n, err := buffer(ctx)
+
+		if err != nil {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		buf := make([]byte, n)
+
+		if n, err = Console.Read(buf); err != nil && err != io.EOF {
+			ret.Error = SBI_ERR_FAILED
+			return
+		}
+
+		ctx.Poke(off, buf[:n])
+		ret.Value = int64(n)
+	case EXT_DBCN_CONSOLE_WRITE_BYTE:
+		if _, err := Console.Write([]byte{byte(ctx.X10)}); err != nil {
+			ret.Error = SBI_ERR_FAILED
+		}
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
+
+// legacyConsoleHandler implements the legacy console extensions, which
+// return their value in A0 only
+// (Chapter 5. Legacy Extensions, RISC-V Supervisor Binary Interface
+// Specification v2.0).
+func legacyConsoleHandler(ctx *monitor.ExecCtx) (ret int64) {
+	if Console == nil {
+		return SBI_ERR_NOT_SUPPORTED
+	}
+
+	switch ctx.X17 {
+	case EXT_LEGACY_CONSOLE_PUTCHAR:
+		if _, err := Console.Write([]byte{byte(ctx.X10)}); err != nil {
+			return SBI_ERR_FAILED
+		}
+	case EXT_LEGACY_CONSOLE_GETCHAR:
+		buf := make([]byte, 1)
+
+		if n, _ := Console.Read(buf); n == 0 {
+			return -1
+		}
+
+		return int64(buf[0])
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// GoTEE Extension Function IDs (FIDs)
+const (
+	// EXT_GOTEE_RPC_REQ submits a JSON-RPC request, held in a shared
+	// buffer (A0: address, A1: size), to the execution context RPC server.
+	EXT_GOTEE_RPC_REQ = iota
+	// EXT_GOTEE_RPC_RES copies pending JSON-RPC responses to a shared
+	// buffer (A0: address, A1: size), returning the number of bytes
+	// written.
+	EXT_GOTEE_RPC_RES
+	// EXT_GOTEE_BRIDGE_CALL forwards a JSON-RPC request, held in a shared
+	// buffer (A0: address, A1: size, A2: capacity), to a Trusted Applet
+	// service through the Bridge, returning the response size.
+	EXT_GOTEE_BRIDGE_CALL
+)
+
+// Bridge, if not nil, represents the Normal World to Trusted Applet RPC
+// bridge served through the GoTEE vendor extension.
+var Bridge *monitor.Bridge
+
+// goteeHandler implements the GoTEE vendor extension, which bridges S-mode
+// guest calls to the execution context RPC server (see monitor.ExecCtx.Server)
+// with the same request/response semantics of syscall.Call() on Trusted
+// Applets, as well as to Trusted Applet services (see Bridge).
+//
+// Shared buffers must be within the execution context memory and are
+// addressed physically.
+func goteeHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	if ctx.X16 == EXT_GOTEE_BRIDGE_CALL {
+		return bridgeHandler(ctx)
+	}
+
+	if ctx.Server == nil {
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+		return
+	}
+
+	addr := uint(ctx.X10)
+	size := int(ctx.X11)
+
+	if _, err := ctx.MemoryRegion(addr, size); err != nil {
+		ret.Error = SBI_ERR_INVALID_ADDRESS
+		return
+	}
+
+	switch ctx.X16 {
+	case EXT_GOTEE_RPC_REQ:
+		if err := ctx.Request(addr, size); err != nil {
+			ret.Error = SBI_ERR_FAILED
+		}
+	case EXT_GOTEE_RPC_RES:
+		n, err := ctx.Response(addr, size)
+
+		if err != nil {
+			ret.Error = SBI_ERR_FAILED
+		}
+
+		ret.Value = int64(n)
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
+
+// bridgeHandler forwards S-mode guest requests to Trusted Applet services
+// (see monitor.Bridge).
+func bridgeHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	if Bridge == nil {
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+		return
+	}
+
+	n, err := Bridge.Handle(ctx, uint(ctx.X10), int(ctx.X11), int(ctx.X12))
+	ret.Value = int64(n)
+
+	if err != nil {
+		ret.Error = SBI_ERR_INVALID_PARAM
+	}
+
+	return
+}
@@ -0,0 +1,88 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// HSM Extension Function IDs (FIDs)
+const (
+	EXT_HSM_HART_START = iota
+	EXT_HSM_HART_STOP
+	EXT_HSM_HART_GET_STATUS
+	EXT_HSM_HART_SUSPEND
+)
+
+// HSM suspend types
+const (
+	HSM_SUSPEND_RETENTIVE     = 0x00000000
+	HSM_SUSPEND_NON_RETENTIVE = 0x80000000
+)
+
+// hart returns the execution context bound to the argument hart identifier,
+// within the execution context hart group.
+func hart(ctx *monitor.ExecCtx, id uint64) (*monitor.ExecCtx, bool) {
+	h, err := ctx.Harts.Get(id)
+
+	return h, err == nil
+}
+
+// hsmHandler implements the Hart State Management Extension
+// (Chapter 9. Hart State Management Extension (EID #0x48534D "HSM"), RISC-V
+// Supervisor Binary Interface Specification v1.0.0).
+//
+// Secondary harts are started and stopped through the monitor hart state
+// machine (see monitor.Harts), the extension is not supported for execution
+// contexts outside a hart group. Only default retentive suspension is
+// supported and it returns immediately.
+func hsmHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	if ctx.Harts == nil {
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+		return
+	}
+
+	switch ctx.X16 {
+	case EXT_HSM_HART_START:
+		target, ok := hart(ctx, ctx.X10)
+
+		switch {
+		case !ok:
+			ret.Error = SBI_ERR_INVALID_PARAM
+		case target.HartState() != monitor.HartStopped:
+			ret.Error = SBI_ERR_ALREADY_AVAILABLE
+		case target.HartStart(ctx.X11, ctx.X12) != nil:
+			ret.Error = SBI_ERR_INVALID_ADDRESS
+		}
+	case EXT_HSM_HART_STOP:
+		if err := ctx.HartStop(); err != nil {
+			ret.Error = SBI_ERR_FAILED
+		}
+	case EXT_HSM_HART_GET_STATUS:
+		target, ok := hart(ctx, ctx.X10)
+
+		if !ok {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		ret.Value = int64(target.HartState())
+	case EXT_HSM_HART_SUSPEND:
+		switch suspendType := uint32(ctx.X10); {
+		case suspendType == HSM_SUSPEND_RETENTIVE:
+			// resume immediately, as on a spurious wake-up
+		case suspendType > HSM_SUSPEND_RETENTIVE && suspendType < 0x10000000,
+			suspendType > HSM_SUSPEND_NON_RETENTIVE && suspendType < 0x90000000:
+			ret.Error = SBI_ERR_INVALID_PARAM
+		default:
+			ret.Error = SBI_ERR_NOT_SUPPORTED
+		}
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
@@ -0,0 +1,86 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// SBI Implementation IDs
+// (4.9 SBI Implementation IDs, RISC-V Supervisor Binary Interface
+// Specification v2.0).
+const (
+	IMP_ID_BBL       = 0
+	IMP_ID_OPENSBI   = 1
+	IMP_ID_XVISOR    = 2
+	IMP_ID_KVM       = 3
+	IMP_ID_RUSTSBI   = 4
+	IMP_ID_DIOSIX    = 5
+	IMP_ID_COFFER    = 6
+	IMP_ID_XEN       = 7
+	IMP_ID_POLARFIRE = 8
+	IMP_ID_COREBOOT  = 9
+	IMP_ID_OREBOOT   = 10
+	IMP_ID_BHYVE     = 11
+
+	// IMP_ID_GOTEE is not registered and it is therefore chosen outside
+	// of the registered range ("GoTEE" in ASCII).
+	IMP_ID_GOTEE = 0x476f544545
+)
+
+const modulePath = "github.com/usbarmory/GoTEE"
+
+var (
+	// ImplementationID is the SBI implementation ID reported to execution
+	// contexts.
+	ImplementationID uint64 = IMP_ID_GOTEE
+
+	// ImplementationVersion is the SBI implementation version reported to
+	// execution contexts, it defaults to the GoTEE module version encoded
+	// as major<<16 | minor<<8 | patch.
+	ImplementationVersion = version()
+
+	// MachineIDs controls whether the hart mvendorid, marchid and mimpid
+	// values are reported to execution contexts, zero is reported when
+	// false to avoid disclosing hardware details.
+	MachineIDs bool
+)
+
+// version returns the encoded GoTEE module version from the build
+// information, zero is returned for development or pseudo versions.
+func version() (v uint64) {
+	info, ok := debug.ReadBuildInfo()
+
+	if !ok {
+		return
+	}
+
+	mod := &info.Main
+
+	for _, dep := range info.Deps {
+		if dep.Path == modulePath {
+			mod = dep
+		}
+	}
+
+	if mod.Path != modulePath || strings.Contains(mod.Version, "-") {
+		return
+	}
+
+	for i, n := range strings.SplitN(strings.TrimPrefix(mod.Version, "v"), ".", 3) {
+		x, err := strconv.ParseUint(n, 10, 8)
+
+		if err != nil {
+			return 0
+		}
+
+		v |= x << (16 - 8*i)
+	}
+
+	return
+}
@@ -0,0 +1,89 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"errors"
+	"slices"
+
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// IPI Extension Function IDs (FIDs)
+const (
+	EXT_IPI_SEND_IPI = iota
+)
+
+// targets returns the hart identifiers selected by a hart mask, relative to
+// the argument base, among the execution context hart group
+// (3.1 Hart list parameter, RISC-V Supervisor Binary Interface Specification
+// v1.0.0).
+func targets(ctx *monitor.ExecCtx, mask uint64, base uint64) (harts []uint64, err error) {
+	ids := []uint64{ctx.Hart}
+
+	if ctx.Harts != nil {
+		ids = ctx.Harts.IDs()
+	}
+
+	if base == ^uint64(0) {
+		return ids, nil
+	}
+
+	for i := uint64(0); i < 64; i++ {
+		if mask&(1<<i) == 0 {
+			continue
+		}
+
+		if !slices.Contains(ids, base+i) {
+			return nil, errors.New("invalid hart")
+		}
+
+		harts = append(harts, base+i)
+	}
+
+	return
+}
+
+// send marks a request as pending on the argument harts, the request is
+// served once their execution context is scheduled (see monitor.ExecCtx.Signal()).
+func send(ctx *monitor.ExecCtx, harts []uint64, req int) (err error) {
+	for _, hart := range harts {
+		target := ctx
+
+		if ctx.Harts != nil {
+			if target, err = ctx.Harts.Get(hart); err != nil {
+				return
+			}
+		}
+
+		target.Signal(req)
+	}
+
+	return
+}
+
+// ipiHandler implements the IPI Extension
+// (Chapter 7. IPI Extension (EID #0x735049 "sPI: s-mode IPI"), RISC-V
+// Supervisor Binary Interface Specification v1.0.0).
+func ipiHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	switch ctx.X16 {
+	case EXT_IPI_SEND_IPI:
+		harts, err := targets(ctx, ctx.X10, ctx.X11)
+
+		if err != nil {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		if err = send(ctx, harts, monitor.RequestIPI); err != nil {
+			ret.Error = SBI_ERR_INVALID_PARAM
+		}
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
@@ -0,0 +1,49 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// RFENCE Extension Function IDs (FIDs)
+const (
+	EXT_RFENCE_REMOTE_FENCE_I = iota
+	EXT_RFENCE_REMOTE_SFENCE_VMA
+	EXT_RFENCE_REMOTE_SFENCE_VMA_ASID
+	EXT_RFENCE_REMOTE_HFENCE_GVMA_VMID
+	EXT_RFENCE_REMOTE_HFENCE_GVMA
+	EXT_RFENCE_REMOTE_HFENCE_VVMA_ASID
+	EXT_RFENCE_REMOTE_HFENCE_VVMA
+)
+
+// rfenceHandler implements the RFENCE Extension
+// (Chapter 8. RFENCE Extension (EID #0x52464E43 "RFNC"), RISC-V Supervisor
+// Binary Interface Specification v1.0.0).
+//
+// All remote fences are served by flushing the entire address translation
+// cache, along with the instruction cache, before the target harts execution
+// contexts are next scheduled (see monitor.Harts). Hypervisor fences are not
+// supported.
+func rfenceHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	switch ctx.X16 {
+	case EXT_RFENCE_REMOTE_FENCE_I, EXT_RFENCE_REMOTE_SFENCE_VMA, EXT_RFENCE_REMOTE_SFENCE_VMA_ASID:
+		harts, err := targets(ctx, ctx.X10, ctx.X11)
+
+		if err != nil {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		if err = send(ctx, harts, monitor.RequestFence); err != nil {
+			ret.Error = SBI_ERR_INVALID_PARAM
+		}
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
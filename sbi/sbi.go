@@ -17,13 +17,29 @@ import (
 )
 
 const (
-	SBI_MAJOR = 1
+	SBI_MAJOR = 2
 	SBI_MINOR = 0
 )
 
 // Supported SBI Extension IDs (EID)
 const (
-	EXT_BASE = 0x10
+	EXT_BASE   = 0x10
+	EXT_TIME   = 0x54494D45
+	EXT_IPI    = 0x735049
+	EXT_RFENCE = 0x52464E43
+	EXT_HSM    = 0x48534D
+	EXT_DBCN   = 0x4442434E
+	EXT_SRST   = 0x53525354
+
+	// EXT_GOTEE is the GoTEE vendor extension, within the Vendor-Specific
+	// Extension Space ("GTE" in ASCII).
+	EXT_GOTEE = 0x09475445
+)
+
+// Supported legacy SBI Extension IDs (EID)
+const (
+	EXT_LEGACY_CONSOLE_PUTCHAR = 0x01
+	EXT_LEGACY_CONSOLE_GETCHAR = 0x02
 )
 
 // Base Extension Function IDs (FIDs)
@@ -55,16 +71,43 @@ type sbiret struct {
 	Value int64
 }
 
+// ExtensionHandler represents a custom SBI extension handler, it returns the
+// SBI error and value to be passed to the execution context.
+type ExtensionHandler func(ctx *monitor.ExecCtx) (errno int64, value int64)
+
+// Extensions holds custom SBI extensions (e.g. vendor or firmware specific
+// ones) indexed by Extension ID, standard extensions implemented by this
+// package take precedence.
+var Extensions = make(map[uint64]ExtensionHandler)
+
 func baseHandler(ctx *monitor.ExecCtx) (ret sbiret) {
 	switch ctx.X16 {
 	case EXT_BASE_GET_SPEC_VERSION:
 		ret.Value = (SBI_MAJOR << 24) | SBI_MINOR
-	case EXT_BASE_GET_IMP_ID, EXT_BASE_GET_IMP_VERSION:
-		// report no supported EIDs or implementation details
+	case EXT_BASE_GET_IMP_ID:
+		ret.Value = int64(ImplementationID)
+	case EXT_BASE_GET_IMP_VERSION:
+		ret.Value = int64(ImplementationVersion)
 	case EXT_BASE_PROBE_EXT:
-		// report no support for other extensions
+		if probe(ctx, ctx.X10) {
+			ret.Value = 1
+		}
 	case EXT_BASE_GET_MVENDORID, EXT_BASE_GET_MARCHID, EXT_BASE_GET_MIMPID:
-		// zero is always a legal value for these CSRs
+		if !MachineIDs {
+			// zero is always a legal value for these CSRs
+			return
+		}
+
+		vendor, arch, imp := monitor.MachineIDs()
+
+		switch ctx.X16 {
+		case EXT_BASE_GET_MVENDORID:
+			ret.Value = int64(vendor)
+		case EXT_BASE_GET_MARCHID:
+			ret.Value = int64(arch)
+		case EXT_BASE_GET_MIMPID:
+			ret.Value = int64(imp)
+		}
 	default:
 		ret.Error = SBI_ERR_NOT_SUPPORTED
 	}
@@ -72,22 +115,79 @@ func baseHandler(ctx *monitor.ExecCtx) (ret sbiret) {
 	return
 }
 
+// probe returns whether the argument SBI extension is available to the
+// execution context.
+func probe(ctx *monitor.ExecCtx, eid uint64) bool {
+	switch eid {
+	case EXT_BASE, EXT_TIME, EXT_IPI, EXT_RFENCE:
+		return true
+	case EXT_HSM:
+		return ctx.Harts != nil
+	case EXT_DBCN, EXT_LEGACY_CONSOLE_PUTCHAR, EXT_LEGACY_CONSOLE_GETCHAR:
+		return Console != nil
+	case EXT_SRST:
+		return Reset != nil
+	case EXT_GOTEE:
+		return ctx.Server != nil || Bridge != nil
+	}
+
+	_, ok := Extensions[eid]
+
+	return ok
+}
+
 // Handler implements basic support for RISC-V SBI calls raised by an execution
-// context, it provides minimal support for SBI probing by S-mode kernels. Only
-// the Base extension is implemented to report that no other SBI extensions are
-// available.
+// context, it provides support for SBI probing, timer programming and
+// emulated multi-hart operation by S-mode kernels. The Base, Timer, IPI and
+// RFENCE extensions are implemented, while the Debug Console and legacy
+// console extensions are implemented when Console is set and the System Reset
+// extension when Reset is set.
+//
+// The Hart State Management extension is implemented for execution contexts
+// of a hart group (see monitor.Harts), only emulated multi-hart operation is
+// supported as all guest harts run in turn on the Trusted OS hart.
+//
+// The GoTEE vendor extension provides access to the execution context RPC
+// server, while custom extensions can be added through the Extensions table.
+//
+// Machine timer interrupts taken while the execution context is running are
+// also handled, to forward timer interrupts to the execution context.
 func Handler(ctx *monitor.ExecCtx) (err error) {
 	var ret sbiret
 
+	if code, irq := ctx.Cause(); irq {
+		return interruptHandler(ctx, code)
+	}
+
 	switch ctx.X17 {
 	case EXT_BASE:
 		ret = baseHandler(ctx)
+	case EXT_TIME:
+		ret = timeHandler(ctx)
+	case EXT_IPI:
+		ret = ipiHandler(ctx)
+	case EXT_RFENCE:
+		ret = rfenceHandler(ctx)
+	case EXT_HSM:
+		ret = hsmHandler(ctx)
+	case EXT_DBCN:
+		ret = dbcnHandler(ctx)
+	case EXT_SRST:
+		ret = srstHandler(ctx)
+	case EXT_GOTEE:
+		ret = goteeHandler(ctx)
+	case EXT_LEGACY_CONSOLE_PUTCHAR, EXT_LEGACY_CONSOLE_GETCHAR:
+		ctx.Return(uint64(legacyConsoleHandler(ctx)))
+		return
 	default:
-		ret.Error = SBI_ERR_NOT_SUPPORTED
+		if h, ok := Extensions[ctx.X17]; ok {
+			ret.Error, ret.Value = h(ctx)
+		} else {
+			ret.Error = SBI_ERR_NOT_SUPPORTED
+		}
 	}
 
-	ctx.X10 = uint64(ret.Error)
-	ctx.X11 = uint64(ret.Value)
+	ctx.Return(uint64(ret.Error), uint64(ret.Value))
 
 	return
 }
@@ -0,0 +1,76 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// System Reset Extension Function IDs (FIDs)
+const (
+	EXT_SRST_SYSTEM_RESET = iota
+)
+
+// System reset types
+const (
+	SRST_TYPE_SHUTDOWN    = 0x00000000
+	SRST_TYPE_COLD_REBOOT = 0x00000001
+	SRST_TYPE_WARM_REBOOT = 0x00000002
+	SRST_TYPE_VENDOR      = 0xf0000000
+)
+
+// System reset reasons
+const (
+	SRST_REASON_NONE           = 0x00000000
+	SRST_REASON_SYSTEM_FAILURE = 0x00000001
+	SRST_REASON_SBI            = 0xe0000000
+	SRST_REASON_VENDOR         = 0xf0000000
+)
+
+// Reset, if not nil, is invoked on execution context system reset requests to
+// let the Trusted OS apply its policy (e.g. stop the execution context,
+// restart it or reset the board) according to the requested reset type and
+// reason (see SRST_TYPE_*, SRST_REASON_*), it enables the System Reset
+// extension.
+//
+// An error is returned to the execution context as a failed reset request,
+// therefore the function must stop the execution context (see
+// monitor.ExecCtx.Stop()) or reinitialize it when the request is accepted.
+var Reset func(ctx *monitor.ExecCtx, resetType uint32, reason uint32) error
+
+// srstHandler implements the System Reset Extension
+// (Chapter 10. System Reset Extension (EID #0x53525354 "SRST"), RISC-V
+// Supervisor Binary Interface Specification v2.0).
+func srstHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	if Reset == nil {
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+		return
+	}
+
+	switch ctx.X16 {
+	case EXT_SRST_SYSTEM_RESET:
+		resetType := uint32(ctx.X10)
+		reason := uint32(ctx.X11)
+
+		if resetType > SRST_TYPE_WARM_REBOOT && resetType < SRST_TYPE_VENDOR {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		if reason > SRST_REASON_SYSTEM_FAILURE && reason < SRST_REASON_SBI {
+			ret.Error = SBI_ERR_INVALID_PARAM
+			return
+		}
+
+		if err := Reset(ctx, resetType, reason); err != nil {
+			ret.Error = SBI_ERR_FAILED
+		}
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
@@ -0,0 +1,55 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package sbi
+
+import (
+	"fmt"
+
+	"github.com/usbarmory/GoTEE/monitor"
+)
+
+// Timer Extension Function IDs (FIDs)
+const (
+	EXT_TIME_SET_TIMER = iota
+)
+
+// timeHandler implements the Timer Extension
+// (Chapter 6. Timer Extension (EID #0x54494D45 "TIME"), RISC-V Supervisor
+// Binary Interface Specification v1.0.0).
+//
+// The call programs the machine timer of the current hart (see monitor.CLINT)
+// with the requested value and clears any pending Supervisor timer interrupt,
+// which is raised again once the timer fires (see interruptHandler()).
+func timeHandler(ctx *monitor.ExecCtx) (ret sbiret) {
+	switch ctx.X16 {
+	case EXT_TIME_SET_TIMER:
+		monitor.SetTimecmp(monitor.HartID(), ctx.X10)
+		monitor.SetPending(monitor.SupervisorTimerInterrupt, false)
+		monitor.EnableInterrupt(monitor.MachineTimerInterrupt, true)
+	default:
+		ret.Error = SBI_ERR_NOT_SUPPORTED
+	}
+
+	return
+}
+
+// interruptHandler handles machine interrupts taken while the execution
+// context is running.
+//
+// A machine timer interrupt is forwarded to the execution context as a
+// Supervisor timer interrupt, its source is masked until the next timer
+// request.
+func interruptHandler(ctx *monitor.ExecCtx, code uint64) (err error) {
+	switch code {
+	case monitor.MachineTimerInterrupt:
+		monitor.EnableInterrupt(monitor.MachineTimerInterrupt, false)
+		monitor.SetPending(monitor.SupervisorTimerInterrupt, true)
+	default:
+		return fmt.Errorf("unhandled interrupt %d", code)
+	}
+
+	return
+}
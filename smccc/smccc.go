@@ -0,0 +1,283 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+// Package smccc implements a table-driven dispatcher for the Arm SMC Calling
+// Convention (SMCCC), to route secure monitor calls raised by Normal World
+// execution contexts to registered services.
+//
+// The package is architecture independent, call arguments and results are
+// exchanged through a register set which is populated by the caller (see
+// monitor.NonSecureHandler), it can therefore be used on any host.
+//
+// This package implements the following reference specification:
+//   - DEN0028 - SMC Calling Convention - v1.2
+package smccc
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// SMCCC version implemented by the dispatcher.
+const (
+	SMCCC_MAJOR = 1
+	SMCCC_MINOR = 2
+)
+
+// Function Identifier fields
+// (2.5 Function Identifiers, DEN0028 v1.2).
+const (
+	FID_FAST     = 31
+	FID_SMC64    = 30
+	FID_OEN      = 24
+	FID_OEN_MASK = 0x3f
+	FID_MBZ      = 16
+	FID_MBZ_MASK = 0xff
+	FID_NUM_MASK = 0xffff
+)
+
+// Owning Entity Numbers (OEN)
+// (Table 6-4, DEN0028 v1.2).
+const (
+	OEN_ARCH            = 0
+	OEN_CPU             = 1
+	OEN_SIP             = 2
+	OEN_OEM             = 3
+	OEN_STD             = 4
+	OEN_STD_HYP         = 5
+	OEN_VENDOR_HYP      = 6
+	OEN_TRUSTED_APP     = 48
+	OEN_TRUSTED_APP_END = 49
+	OEN_TRUSTED_OS      = 50
+	OEN_TRUSTED_OS_END  = 63
+)
+
+// Return codes
+// (7.1 Return codes, DEN0028 v1.2).
+const (
+	SUCCESS           = 0
+	NOT_SUPPORTED     = -1
+	NOT_REQUIRED      = -2
+	INVALID_PARAMETER = -3
+)
+
+// Arm Architecture Calls
+// (7 Arm Architecture Calls, DEN0028 v1.2).
+const (
+	SMCCC_VERSION       = 0x80000000
+	SMCCC_ARCH_FEATURES = 0x80000001
+	SMCCC_ARCH_SOC_ID   = 0x80000002
+)
+
+// FunctionID represents an SMC or HVC Function Identifier.
+type FunctionID uint32
+
+// Fast returns whether the function is a Fast Call, rather than a Yielding
+// Call.
+func (id FunctionID) Fast() bool {
+	return (id>>FID_FAST)&1 == 1
+}
+
+// SMC64 returns whether the function uses the SMC64/HVC64 calling convention.
+func (id FunctionID) SMC64() bool {
+	return (id>>FID_SMC64)&1 == 1
+}
+
+// Owner returns the function Owning Entity Number.
+func (id FunctionID) Owner() int {
+	return int(id>>FID_OEN) & FID_OEN_MASK
+}
+
+// Number returns the function number within its owning entity range.
+func (id FunctionID) Number() int {
+	return int(id & FID_NUM_MASK)
+}
+
+// Valid returns whether the Function Identifier reserved bits are clear.
+func (id FunctionID) Valid() bool {
+	return !id.Fast() || (id>>FID_MBZ)&FID_MBZ_MASK == 0
+}
+
+// String returns the string form of the Function Identifier.
+func (id FunctionID) String() string {
+	t := "yielding"
+	cc := "SMC32"
+
+	if id.Fast() {
+		t = "fast"
+	}
+
+	if id.SMC64() {
+		cc = "SMC64"
+	}
+
+	return fmt.Sprintf("%#.8x (%s %s, OEN %d, function %d)", uint32(id), t, cc, id.Owner(), id.Number())
+}
+
+// Call represents an SMC or HVC call.
+type Call struct {
+	// Regs holds the call arguments (W0-W7, X0-X7 or R0-R7), with the
+	// Function Identifier in the first register. Results are returned in
+	// the first four registers.
+	Regs [8]uint64
+
+	// AArch32 must be set for calls issued from AArch32 state, as
+	// SMC64/HVC64 calls are not supported from it.
+	AArch32 bool
+
+	// Context is an opaque value, passed by the caller to the service
+	// handlers, representing the calling execution context.
+	Context any
+}
+
+// ID returns the call Function Identifier.
+func (c *Call) ID() FunctionID {
+	return FunctionID(c.Regs[0])
+}
+
+// Return sets the call results, up to four values are returned. Results of
+// SMC32/HVC32 calls, or of calls from AArch32 state, are truncated to 32
+// bits.
+func (c *Call) Return(vals ...int64) {
+	smc32 := c.AArch32 || !c.ID().SMC64()
+
+	for i, v := range vals {
+		if i >= 4 {
+			break
+		}
+
+		c.Regs[i] = uint64(v)
+
+		if smc32 {
+			c.Regs[i] &= 0xffffffff
+		}
+	}
+}
+
+// Handler represents a service call handler, which must set the call results
+// (see Call.Return()). An error is returned only for conditions which must
+// terminate the calling execution context.
+type Handler func(call *Call) error
+
+// Dispatcher represents a table of SMCCC services, indexed by Function
+// Identifier or by Owning Entity Number.
+type Dispatcher struct {
+	sync.RWMutex
+
+	functions map[FunctionID]Handler
+	owners    map[int]Handler
+}
+
+// NewDispatcher returns a dispatcher implementing the Arm Architecture Calls
+// SMCCC_VERSION and SMCCC_ARCH_FEATURES.
+func NewDispatcher() (d *Dispatcher) {
+	d = &Dispatcher{
+		functions: make(map[FunctionID]Handler),
+		owners:    make(map[int]Handler),
+	}
+
+	d.functions[SMCCC_VERSION] = d.version
+	d.functions[SMCCC_ARCH_FEATURES] = d.features
+
+	return
+}
+
+// Register adds a handler for the argument Function Identifier, replacing any
+// previous one.
+func (d *Dispatcher) Register(id FunctionID, h Handler) (err error) {
+	if !id.Valid() {
+		return fmt.Errorf("invalid function identifier %s", id)
+	}
+
+	if id == SMCCC_VERSION || id == SMCCC_ARCH_FEATURES {
+		return errors.New("reserved function identifier")
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.functions[id] = h
+
+	return
+}
+
+// RegisterOwner adds a handler for all Function Identifiers, without a
+// specific handler (see Register()), of the argument Owning Entity Number.
+func (d *Dispatcher) RegisterOwner(oen int, h Handler) (err error) {
+	if oen < 0 || oen > FID_OEN_MASK {
+		return fmt.Errorf("invalid owning entity number %d", oen)
+	}
+
+	d.Lock()
+	defer d.Unlock()
+
+	d.owners[oen] = h
+
+	return
+}
+
+// handler returns the handler for the argument Function Identifier, if any.
+func (d *Dispatcher) handler(id FunctionID) (h Handler, ok bool) {
+	d.RLock()
+	defer d.RUnlock()
+
+	if h, ok = d.functions[id]; ok {
+		return
+	}
+
+	h, ok = d.owners[id.Owner()]
+
+	return
+}
+
+// Implemented returns whether the argument Function Identifier is handled by
+// the dispatcher.
+func (d *Dispatcher) Implemented(id FunctionID) bool {
+	_, ok := d.handler(id)
+	return ok
+}
+
+// Dispatch routes a call to its registered handler, unknown or invalid
+// Function Identifiers are returned NOT_SUPPORTED.
+func (d *Dispatcher) Dispatch(call *Call) (err error) {
+	id := call.ID()
+
+	if !id.Valid() || (call.AArch32 && id.SMC64()) {
+		call.Return(NOT_SUPPORTED)
+		return
+	}
+
+	h, ok := d.handler(id)
+
+	if !ok {
+		call.Return(NOT_SUPPORTED)
+		return
+	}
+
+	return h(call)
+}
+
+// version implements SMCCC_VERSION
+// (7.2 SMCCC_VERSION, DEN0028 v1.2).
+func (d *Dispatcher) version(call *Call) error {
+	call.Return(SMCCC_MAJOR<<16 | SMCCC_MINOR)
+	return nil
+}
+
+// features implements SMCCC_ARCH_FEATURES
+// (7.3 SMCCC_ARCH_FEATURES, DEN0028 v1.2).
+func (d *Dispatcher) features(call *Call) error {
+	id := FunctionID(call.Regs[1])
+
+	if !d.Implemented(id) {
+		call.Return(NOT_SUPPORTED)
+		return nil
+	}
+
+	call.Return(SUCCESS)
+
+	return nil
+}
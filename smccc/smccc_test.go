@@ -0,0 +1,166 @@
+// Copyright (c) The GoTEE authors. All Rights Reserved.
+//
+// Use of this source code is governed by the license
+// that can be found in the LICENSE file.
+
+package smccc
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFunctionID(t *testing.T) {
+	tests := []struct {
+		id     FunctionID
+		fast   bool
+		smc64  bool
+		owner  int
+		number int
+		valid  bool
+	}{
+		{SMCCC_VERSION, true, false, OEN_ARCH, 0, true},
+		{0x84000008, true, false, OEN_STD, 8, true},
+		{0xc4000003, true, true, OEN_STD, 3, true},
+		{0xb2000001, true, false, OEN_TRUSTED_OS, 1, true},
+		{0x32000001, false, false, OEN_TRUSTED_OS, 1, true},
+		{0x32ff0001, false, false, OEN_TRUSTED_OS, 1, true},
+		{0x84010000, true, false, OEN_STD, 0, false},
+	}
+
+	for _, tt := range tests {
+		id := tt.id
+
+		if id.Fast() != tt.fast || id.SMC64() != tt.smc64 || id.Owner() != tt.owner || id.Number() != tt.number || id.Valid() != tt.valid {
+			t.Errorf("%s: fast:%v smc64:%v owner:%d number:%d valid:%v", id.String(), id.Fast(), id.SMC64(), id.Owner(), id.Number(), id.Valid())
+		}
+	}
+
+	if s := FunctionID(0xc4000003).String(); s != "0xc4000003 (fast SMC64, OEN 4, function 3)" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestReturn(t *testing.T) {
+	tests := []struct {
+		name string
+		id   FunctionID
+		vals []int64
+		regs [4]uint64
+	}{
+		{"smc32", 0x84000000, []int64{NOT_SUPPORTED, 1}, [4]uint64{0xffffffff, 1, 0, 0}},
+		{"smc64", 0xc4000000, []int64{NOT_SUPPORTED, 1}, [4]uint64{^uint64(0), 1, 0, 0}},
+		{"truncated", 0x84000000, []int64{1, 2, 3, 4, 5}, [4]uint64{1, 2, 3, 4}},
+	}
+
+	for _, tt := range tests {
+		call := &Call{}
+		call.Regs[0] = uint64(tt.id)
+		call.Regs[4] = 0xaa
+
+		call.Return(tt.vals...)
+
+		if [4]uint64(call.Regs[:4]) != tt.regs || call.Regs[4] != 0xaa {
+			t.Errorf("%s: regs = %#x", tt.name, call.Regs)
+		}
+	}
+}
+
+func TestDispatch(t *testing.T) {
+	const (
+		fid   = FunctionID(0xb2000001)
+		other = FunctionID(0xb2000002)
+		fid64 = FunctionID(0xf2000001)
+	)
+
+	errFatal := errors.New("fatal")
+
+	d := NewDispatcher()
+
+	d.Register(fid, func(call *Call) error {
+		call.Return(SUCCESS, int64(call.Regs[1])+1)
+		return nil
+	})
+
+	d.RegisterOwner(OEN_TRUSTED_OS, func(call *Call) error {
+		call.Return(SUCCESS, 42)
+		return nil
+	})
+
+	d.Register(fid64, func(call *Call) error {
+		return errFatal
+	})
+
+	tests := []struct {
+		name    string
+		regs    []uint64
+		aarch32 bool
+		ret     []uint64
+		err     error
+	}{
+		{"version", []uint64{SMCCC_VERSION}, true, []uint64{SMCCC_MAJOR<<16 | SMCCC_MINOR}, nil},
+		{"features implemented", []uint64{SMCCC_ARCH_FEATURES, uint64(fid)}, true, []uint64{SUCCESS}, nil},
+		{"features owner", []uint64{SMCCC_ARCH_FEATURES, uint64(other)}, true, []uint64{SUCCESS}, nil},
+		{"features missing", []uint64{SMCCC_ARCH_FEATURES, 0x84000000}, true, []uint64{0xffffffff}, nil},
+		{"function", []uint64{uint64(fid), 1}, true, []uint64{SUCCESS, 2}, nil},
+		{"owner", []uint64{uint64(other)}, true, []uint64{SUCCESS, 42}, nil},
+		{"unknown", []uint64{0x84000000}, true, []uint64{0xffffffff}, nil},
+		{"invalid", []uint64{0x84010000}, true, []uint64{0xffffffff}, nil},
+		{"smc64 from aarch32", []uint64{uint64(fid64)}, true, []uint64{0xffffffff}, nil},
+		{"handler error", []uint64{uint64(fid64)}, false, nil, errFatal},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := &Call{AArch32: tt.aarch32}
+			copy(call.Regs[:], tt.regs)
+
+			if err := d.Dispatch(call); err != tt.err {
+				t.Fatalf("err = %v, want %v", err, tt.err)
+			}
+
+			for i, r := range tt.ret {
+				if call.Regs[i] != r {
+					t.Errorf("R%d = %#x, want %#x", i, call.Regs[i], r)
+				}
+			}
+		})
+	}
+}
+
+func TestRegister(t *testing.T) {
+	d := NewDispatcher()
+	h := func(call *Call) error { return nil }
+
+	if err := d.Register(SMCCC_VERSION, h); err == nil {
+		t.Error("expected reserved function error")
+	}
+
+	if err := d.Register(SMCCC_ARCH_FEATURES, h); err == nil {
+		t.Error("expected reserved function error")
+	}
+
+	if err := d.Register(0x84010000, h); err == nil {
+		t.Error("expected invalid function error")
+	}
+
+	if err := d.RegisterOwner(FID_OEN_MASK+1, h); err == nil {
+		t.Error("expected invalid owner error")
+	}
+
+	if err := d.RegisterOwner(-1, h); err == nil {
+		t.Error("expected invalid owner error")
+	}
+
+	if d.Implemented(0x84000000) {
+		t.Error("unexpected implemented function")
+	}
+
+	if err := d.Register(0x84000000, h); err != nil {
+		t.Fatal(err)
+	}
+
+	if !d.Implemented(0x84000000) || !d.Implemented(SMCCC_VERSION) {
+		t.Error("missing implemented function")
+	}
+}
@@ -12,4 +12,6 @@ const (
 	SYS_GETRANDOM
 	SYS_RPC_REQ
 	SYS_RPC_RES
+	SYS_RPC_SRV_REQ
+	SYS_RPC_SRV_RES
 )
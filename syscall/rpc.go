@@ -72,3 +72,22 @@ func Call(serviceMethod string, args interface{}, reply interface{}) error {
 
 	return NewClient().Call(serviceMethod, args, reply)
 }
+
+// Serve serves RPC requests forwarded by the supervisor (e.g. from the Normal
+// World, see monitor.Bridge) with the argument server, it returns only on
+// stream errors.
+//
+// Requests are served sequentially, as the applet is suspended by the
+// supervisor while waiting for the next request.
+func Serve(server *rpc.Server) (err error) {
+	codec := jsonrpc.NewServerCodec(&Stream{
+		ReadSyscall:  SYS_RPC_SRV_REQ,
+		WriteSyscall: SYS_RPC_SRV_RES,
+	})
+
+	for {
+		if err = server.ServeRequest(codec); err != nil {
+			return
+		}
+	}
+}